@@ -0,0 +1,136 @@
+// Package authtest runs a local identity provider for tests: a JWKS endpoint
+// and a signer for tokens it will accept.
+package authtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	Issuer   string = "https://issuer.test"
+	Audience string = "service-connect"
+)
+
+type Provider struct {
+	URL string
+
+	mu       sync.Mutex
+	requests int
+	delay    time.Duration
+	kid      string
+	key      *rsa.PrivateKey
+	keys     map[string]*rsa.PublicKey
+}
+
+// NewProvider starts a JWKS server that is closed when the test ends.
+func NewProvider(t *testing.T) *Provider {
+	t.Helper()
+
+	p := &Provider{keys: map[string]*rsa.PublicKey{}}
+	p.Rotate(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(p.serveJWKS))
+	t.Cleanup(ts.Close)
+	p.URL = ts.URL
+
+	return p
+}
+
+// Rotate adds a new signing key and signs subsequent tokens with it, like a
+// provider rolling its keys. Previous keys stay published.
+func (p *Provider) Rotate(t *testing.T) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.kid = fmt.Sprintf("key-%d", len(p.keys)+1)
+	p.key = key
+	p.keys[p.kid] = &key.PublicKey
+}
+
+// Token signs a token with valid defaults for the issuer, audience and expiry,
+// modify can override any claim.
+func (p *Provider) Token(t *testing.T, subject string, modify func(*jwt.RegisteredClaims)) string {
+	t.Helper()
+
+	claims := &jwt.RegisteredClaims{
+		Issuer:    Issuer,
+		Subject:   subject,
+		Audience:  jwt.ClaimStrings{Audience},
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	if modify != nil {
+		modify(claims)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.kid
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// SetDelay makes the JWKS endpoint wait before it answers, like a slow provider.
+func (p *Provider) SetDelay(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.delay = d
+}
+
+// Requests is the number of times the JWKS has been fetched.
+func (p *Provider) Requests() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests
+}
+
+func (p *Provider) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.requests++
+	delay := p.delay
+	p.mu.Unlock()
+
+	// without the lock, Token and Rotate go on while the request waits
+	time.Sleep(delay)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys := []map[string]string{}
+	for kid, key := range p.keys {
+		keys = append(keys, map[string]string{
+			"kid": kid,
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrKeyNotFound = errors.New("signing key not found in jwks")
+
+// a fetch outlives the request that started it, other requests wait for it too
+const fetchTimeout = 10 * time.Second
+
+// JWKS caches the signing keys published by an identity provider. Keys are
+// refetched every RefreshInterval, and early when a token names an unknown kid
+// (the provider rotated its keys), but never more than once per MinRefreshInterval
+// so that forged kids cannot be used to hammer the provider. Concurrent refreshes
+// share one fetch, which runs without holding the lock so that cached keys are
+// served while the provider answers.
+type JWKS struct {
+	URL                string
+	Client             *http.Client
+	RefreshInterval    time.Duration
+	MinRefreshInterval time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// the fetch in flight, nil when there is none
+	fetching *fetch
+}
+
+type fetch struct {
+	done chan struct{}
+	// set before done is closed
+	err error
+}
+
+func NewJWKS(url string, refreshInterval time.Duration) *JWKS {
+	return &JWKS{
+		URL:                url,
+		Client:             &http.Client{Timeout: 5 * time.Second},
+		RefreshInterval:    refreshInterval,
+		MinRefreshInterval: 10 * time.Second,
+	}
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Key returns the public key with the given kid.
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	stale := time.Since(j.fetchedAt) > j.RefreshInterval
+	key, ok := j.keys[kid]
+	if ok && !stale {
+		j.mu.Unlock()
+		return key, nil
+	}
+	if !stale && time.Since(j.fetchedAt) <= j.MinRefreshInterval {
+		j.mu.Unlock()
+		return nil, fmt.Errorf("%w: kid %q", ErrKeyNotFound, kid)
+	}
+	f := j.refresh()
+	j.mu.Unlock()
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		if ok {
+			return key, nil
+		}
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		// keep serving the cached keys if the provider is briefly unavailable
+		if ok {
+			return key, nil
+		}
+		return nil, f.err
+	}
+
+	j.mu.Lock()
+	key, ok = j.keys[kid]
+	j.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: kid %q", ErrKeyNotFound, kid)
+	}
+	return key, nil
+}
+
+// refresh starts a fetch unless one is in flight and returns it, j.mu must be held.
+func (j *JWKS) refresh() *fetch {
+	if j.fetching != nil {
+		return j.fetching
+	}
+
+	f := &fetch{done: make(chan struct{})}
+	j.fetching = f
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+		keys, err := j.fetchKeys(ctx)
+
+		j.mu.Lock()
+		if err == nil {
+			j.keys = keys
+			j.fetchedAt = time.Now()
+		}
+		j.fetching = nil
+		j.mu.Unlock()
+
+		f.err = err
+		close(f.done)
+	}()
+	return f
+}
+
+func (j *JWKS) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := j.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks: unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decode jwks: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, v := range set.Keys {
+		if v.Use != "" && v.Use != "sig" {
+			continue
+		}
+		key, err := v.publicKey()
+		if err != nil {
+			// skip key types we do not support instead of failing every token
+			continue
+		}
+		keys[v.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type claimsKey struct{}
+
+// Verifier validates bearer tokens issued by an OIDC provider.
+type Verifier struct {
+	keys   *JWKS
+	parser *jwt.Parser
+}
+
+func NewVerifier(keys *JWKS, issuer string, audience string) *Verifier {
+	return &Verifier{
+		keys: keys,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+			jwt.WithIssuer(issuer),
+			jwt.WithAudience(audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(30*time.Second),
+		),
+	}
+}
+
+func (v *Verifier) Verify(ctx context.Context, token string) (*jwt.RegisteredClaims, error) {
+	claims := &jwt.RegisteredClaims{}
+
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid")
+		}
+		return v.keys.Key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func WithClaims(ctx context.Context, claims *jwt.RegisteredClaims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFrom returns nil for anonymous requests.
+func ClaimsFrom(ctx context.Context) *jwt.RegisteredClaims {
+	claims, _ := ctx.Value(claimsKey{}).(*jwt.RegisteredClaims)
+	return claims
+}
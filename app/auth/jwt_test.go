@@ -0,0 +1,180 @@
+package auth_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sasaki-q/private/auth"
+	"github.com/sasaki-q/private/auth/authtest"
+)
+
+func newVerifier(p *authtest.Provider) *auth.Verifier {
+	return auth.NewVerifier(auth.NewJWKS(p.URL, time.Hour), authtest.Issuer, authtest.Audience)
+}
+
+func TestVerify(t *testing.T) {
+	p := authtest.NewProvider(t)
+	verifier := newVerifier(p)
+
+	tests := []struct {
+		name    string
+		modify  func(*jwt.RegisteredClaims)
+		wantErr bool
+	}{
+		{name: "valid"},
+		{name: "expired", modify: func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour)) }, wantErr: true},
+		{name: "no expiry", modify: func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }, wantErr: true},
+		{name: "other audience", modify: func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"other"} }, wantErr: true},
+		{name: "other issuer", modify: func(c *jwt.RegisteredClaims) { c.Issuer = "https://evil.test" }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifier.Verify(context.Background(), p.Token(t, "user-1", tt.modify))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("want an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != "user-1" {
+				t.Errorf("subject = %q, want %q", claims.Subject, "user-1")
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsOtherSigner(t *testing.T) {
+	p := authtest.NewProvider(t)
+	other := authtest.NewProvider(t)
+
+	// same kid, different key
+	if _, err := newVerifier(p).Verify(context.Background(), other.Token(t, "user-1", nil)); err == nil {
+		t.Fatal("want an error, got nil")
+	}
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	p := authtest.NewProvider(t)
+	keys := auth.NewJWKS(p.URL, time.Hour)
+	keys.MinRefreshInterval = 0
+	verifier := auth.NewVerifier(keys, authtest.Issuer, authtest.Audience)
+
+	if _, err := verifier.Verify(context.Background(), p.Token(t, "user-1", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(context.Background(), p.Token(t, "user-1", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Requests(); got != 1 {
+		t.Errorf("jwks fetched %d times before rotation, want 1", got)
+	}
+
+	p.Rotate(t)
+	if _, err := verifier.Verify(context.Background(), p.Token(t, "user-1", nil)); err != nil {
+		t.Fatalf("token signed with the rotated key: %s", err)
+	}
+	if got := p.Requests(); got != 2 {
+		t.Errorf("jwks fetched %d times after rotation, want 2", got)
+	}
+}
+
+func TestVerifyUnknownKidIsRateLimited(t *testing.T) {
+	p := authtest.NewProvider(t)
+	verifier := newVerifier(p)
+
+	if _, err := verifier.Verify(context.Background(), p.Token(t, "user-1", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Rotate(t)
+	for i := 0; i < 3; i++ {
+		if _, err := verifier.Verify(context.Background(), p.Token(t, "user-1", nil)); err == nil {
+			t.Fatal("want an error within MinRefreshInterval, got nil")
+		}
+	}
+	if got := p.Requests(); got != 1 {
+		t.Errorf("jwks fetched %d times, want 1", got)
+	}
+}
+
+func TestVerifyConcurrentRefreshFetchesOnce(t *testing.T) {
+	p := authtest.NewProvider(t)
+	p.SetDelay(100 * time.Millisecond)
+	verifier := newVerifier(p)
+	token := p.Token(t, "user-1", nil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := verifier.Verify(context.Background(), token)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := p.Requests(); got != 1 {
+		t.Errorf("jwks fetched %d times, want 1", got)
+	}
+}
+
+func TestVerifyCachedKeyDuringRefresh(t *testing.T) {
+	p := authtest.NewProvider(t)
+	keys := auth.NewJWKS(p.URL, time.Hour)
+	keys.MinRefreshInterval = 0
+	verifier := auth.NewVerifier(keys, authtest.Issuer, authtest.Audience)
+
+	cached := p.Token(t, "user-1", nil)
+	if _, err := verifier.Verify(context.Background(), cached); err != nil {
+		t.Fatal(err)
+	}
+
+	// an unknown kid starts a slow refresh
+	p.SetDelay(time.Second)
+	p.Rotate(t)
+	rotated := make(chan error, 1)
+	go func() {
+		_, err := verifier.Verify(context.Background(), p.Token(t, "user-1", nil))
+		rotated <- err
+	}()
+	for p.Requests() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := verifier.Verify(ctx, cached); err != nil {
+		t.Fatalf("token signed with a cached key during a refresh: %s", err)
+	}
+
+	if err := <-rotated; err != nil {
+		t.Fatalf("token signed with the rotated key: %s", err)
+	}
+}
+
+func TestVerifyGivesUpWithTheContext(t *testing.T) {
+	p := authtest.NewProvider(t)
+	p.SetDelay(time.Second)
+	verifier := newVerifier(p)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := verifier.Verify(ctx, p.Token(t, "user-1", nil)); err == nil {
+		t.Fatal("want an error once the context is done, got nil")
+	}
+}
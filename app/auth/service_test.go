@@ -0,0 +1,91 @@
+package auth_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sasaki-q/private/auth"
+)
+
+const (
+	secret      string = "0123456789abcdef0123456789abcdef"
+	otherSecret string = "fedcba9876543210fedcba9876543210"
+)
+
+func TestServiceVerify(t *testing.T) {
+	now := time.Now()
+	verifier := auth.NewServiceVerifier(map[string]string{"client_container": secret, "billing_container": otherSecret}, time.Minute)
+	verifier.Now = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		signer  *auth.Signer
+		tamper  func(*http.Request)
+		wantErr error
+	}{
+		{name: "declared caller", signer: auth.NewSigner("client_container", secret)},
+		{name: "undeclared caller", signer: auth.NewSigner("other_container", secret), wantErr: auth.ErrUnknownCaller},
+		{name: "other secret", signer: auth.NewSigner("client_container", "0000000000000000000000000000000000"), wantErr: auth.ErrInvalidSignature},
+		// a caller holding its own secret cannot sign as another declared caller
+		{name: "other caller's name", signer: auth.NewSigner("client_container", otherSecret), wantErr: auth.ErrInvalidSignature},
+		{
+			name:    "old signature",
+			signer:  &auth.Signer{Caller: "client_container", Secret: []byte(secret), Now: func() time.Time { return now.Add(-2 * time.Minute) }},
+			wantErr: auth.ErrExpiredSignature,
+		},
+		{
+			name:    "spoofed identity",
+			signer:  auth.NewSigner("billing_container", otherSecret),
+			tamper:  func(r *http.Request) { r.Header.Set(auth.HeaderServiceIdentity, "client_container") },
+			wantErr: auth.ErrInvalidSignature,
+		},
+		{
+			name:    "other path",
+			signer:  auth.NewSigner("client_container", secret),
+			tamper:  func(r *http.Request) { r.URL.Path = "/admin" },
+			wantErr: auth.ErrInvalidSignature,
+		},
+		{
+			name:    "other query",
+			signer:  auth.NewSigner("client_container", secret),
+			tamper:  func(r *http.Request) { r.URL.RawQuery = "verbose=0" },
+			wantErr: auth.ErrInvalidSignature,
+		},
+		{
+			name:    "dropped query",
+			signer:  auth.NewSigner("client_container", secret),
+			tamper:  func(r *http.Request) { r.URL.RawQuery = "" },
+			wantErr: auth.ErrInvalidSignature,
+		},
+		{name: "unsigned", wantErr: auth.ErrMissingSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/hc?verbose=1", nil)
+			if tt.signer != nil {
+				tt.signer.Sign(req)
+			}
+			if tt.tamper != nil {
+				tt.tamper(req)
+			}
+
+			caller, err := verifier.Verify(req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if caller != "client_container" {
+				t.Errorf("caller = %q, want %q", caller, "client_container")
+			}
+		})
+	}
+}
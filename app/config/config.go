@@ -0,0 +1,429 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is loaded once at startup: defaults, then the optional JSON file named by
+// CONFIG_FILE, then environment variables, which win over both.
+type Config struct {
+	Port          int    `json:"port"`
+	ContainerName string `json:"container_name"`
+	LogLevel      string `json:"log_level"`
+	// serves the gRPC health and ConnectService services, 0 disables it
+	GRPCPort int `json:"grpc_port"`
+	// serves /metrics on localhost only, for the collector next to the app in the
+	// task, 0 disables it
+	MetricsPort int `json:"metrics_port"`
+
+	// the service called by /connect, empty host disables it
+	UpstreamHost string `json:"upstream_host"`
+	UpstreamPort int    `json:"upstream_port"`
+	// "http" calls the upstream's /hc, "grpc" its ConnectService on UpstreamPort
+	UpstreamProtocol string   `json:"upstream_protocol"`
+	UpstreamTimeout  Duration `json:"upstream_timeout"`
+
+	HealthCheckTimeout Duration `json:"health_check_timeout"`
+	// how often the upstream is pinged for logs and metrics, it is not part of readiness
+	UpstreamCheckInterval Duration `json:"upstream_check_interval"`
+	DrainPeriod           Duration `json:"drain_period"`
+	ShutdownTimeout       Duration `json:"shutdown_timeout"`
+
+	CORS CORS `json:"cors"`
+	Auth Auth `json:"auth"`
+
+	ServiceAuth ServiceAuth `json:"service_auth"`
+	RateLimit   RateLimit   `json:"rate_limit"`
+}
+
+type CORS struct {
+	// exact origins, "*", or patterns such as https://*.example.com. Empty disables CORS.
+	AllowOrigins     []string `json:"allow_origins"`
+	AllowMethods     []string `json:"allow_methods"`
+	AllowHeaders     []string `json:"allow_headers"`
+	ExposeHeaders    []string `json:"expose_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAge           Duration `json:"max_age"`
+}
+
+// Auth requires a bearer JWT on every route but ExemptPaths when JWKSURL is set.
+type Auth struct {
+	JWKSURL         string   `json:"jwks_url"`
+	Issuer          string   `json:"issuer"`
+	Audience        string   `json:"audience"`
+	RefreshInterval Duration `json:"refresh_interval"`
+	ExemptPaths     []string `json:"exempt_paths"`
+}
+
+func (a Auth) Enabled() bool {
+	return a.JWKSURL != ""
+}
+
+// ServiceAuth signs calls to the upstream with this service's own Secret when it
+// is set, and requires signed calls from AllowedCallers on Paths when
+// AllowedCallers is set. Each caller has its own secret, read from the variable
+// CallerSecretEnv names, so holding one caller's secret does not allow signing as
+// another.
+type ServiceAuth struct {
+	Secret         string            `json:"-"`
+	AllowedCallers []string          `json:"allowed_callers"`
+	CallerSecrets  map[string]string `json:"-"`
+	// "*" protects every path but ExemptPaths
+	Paths       []string `json:"paths"`
+	ExemptPaths []string `json:"exempt_paths"`
+	MaxSkew     Duration `json:"max_skew"`
+}
+
+// CallerSecretEnv is the variable holding caller's secret, e.g.
+// SERVICE_AUTH_CALLER_SECRET_CLIENT_CONTAINER for client_container.
+func CallerSecretEnv(caller string) string {
+	return "SERVICE_AUTH_CALLER_SECRET_" + strings.ToUpper(nonAlphanumeric.ReplaceAllString(caller, "_"))
+}
+
+func (a ServiceAuth) SigningEnabled() bool {
+	return a.Secret != ""
+}
+
+func (a ServiceAuth) VerificationEnabled() bool {
+	return len(a.AllowedCallers) > 0
+}
+
+// RateLimit allows ClientRate requests per second to each client, keyed by the
+// authenticated subject or the client IP, and GlobalRate across all of them.
+// IPRate is checked before authentication, so requests failing it are limited
+// too. A zero rate disables that limit.
+type RateLimit struct {
+	IPRate      float64  `json:"ip_rate"`
+	IPBurst     int      `json:"ip_burst"`
+	ClientRate  float64  `json:"client_rate"`
+	ClientBurst int      `json:"client_burst"`
+	GlobalRate  float64  `json:"global_rate"`
+	GlobalBurst int      `json:"global_burst"`
+	ExemptPaths []string `json:"exempt_paths"`
+}
+
+func (r RateLimit) IPEnabled() bool {
+	return r.IPRate > 0
+}
+
+func (r RateLimit) Enabled() bool {
+	return r.ClientRate > 0 || r.GlobalRate > 0
+}
+
+// Duration reads "15s" style strings from the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func Default() Config {
+	return Config{
+		Port:                  8080,
+		LogLevel:              "info",
+		UpstreamProtocol:      "http",
+		UpstreamTimeout:       Duration{5 * time.Second},
+		HealthCheckTimeout:    Duration{2 * time.Second},
+		UpstreamCheckInterval: Duration{10 * time.Second},
+		DrainPeriod:           Duration{15 * time.Second},
+		ShutdownTimeout:       Duration{10 * time.Second},
+		CORS: CORS{
+			AllowMethods: []string{"GET", "HEAD", "PUT", "PATCH", "POST", "DELETE"},
+			AllowHeaders: []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		},
+		Auth: Auth{
+			RefreshInterval: Duration{time.Hour},
+			ExemptPaths:     []string{"/hc", "/livez", "/readyz"},
+		},
+		ServiceAuth: ServiceAuth{
+			Paths:       []string{"*"},
+			ExemptPaths: []string{"/livez", "/readyz", "/grpc.health.v1.Health/Check", "/grpc.health.v1.Health/Watch"},
+			MaxSkew:     Duration{time.Minute},
+		},
+		RateLimit: RateLimit{
+			ExemptPaths: []string{"/hc", "/livez", "/readyz"},
+		},
+	}
+}
+
+// Load returns an error listing every invalid value, not only the first one.
+func Load() (Config, error) {
+	cfg := Default()
+	var errs []error
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.readFile(path); err != nil {
+			errs = append(errs, fmt.Errorf("CONFIG_FILE=%q: %w", path, err))
+		}
+	}
+
+	errs = append(errs, cfg.readEnv()...)
+	errs = append(errs, cfg.Validate()...)
+
+	if len(errs) > 0 {
+		return cfg, fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return cfg, nil
+}
+
+func (c *Config) UpstreamAddress() string {
+	return net.JoinHostPort(c.UpstreamHost, strconv.Itoa(c.UpstreamPort))
+}
+
+func (c *Config) readFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, c)
+}
+
+func (c *Config) readEnv() []error {
+	var errs []error
+
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	num := func(key string, dst *int) {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s=%q: not a number", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	float := func(key string, dst *float64) {
+		if v, ok := os.LookupEnv(key); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s=%q: not a number", key, v))
+				return
+			}
+			*dst = f
+		}
+	}
+	list := func(key string, dst *[]string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = nil
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*dst = append(*dst, item)
+				}
+			}
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s=%q: not a boolean", key, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	dur := func(key string, dst *Duration) {
+		if v, ok := os.LookupEnv(key); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s=%q: not a duration", key, v))
+				return
+			}
+			dst.Duration = d
+		}
+	}
+
+	num("PORT", &c.Port)
+	str("CONTAINER_NAME", &c.ContainerName)
+	str("LOG_LEVEL", &c.LogLevel)
+	num("GRPC_PORT", &c.GRPCPort)
+	num("METRICS_PORT", &c.MetricsPort)
+	str("CONTAINER_HOST", &c.UpstreamHost)
+	num("CONTAINER_PORT", &c.UpstreamPort)
+	str("UPSTREAM_PROTOCOL", &c.UpstreamProtocol)
+	dur("UPSTREAM_TIMEOUT", &c.UpstreamTimeout)
+	dur("HEALTH_CHECK_TIMEOUT", &c.HealthCheckTimeout)
+	dur("UPSTREAM_CHECK_INTERVAL", &c.UpstreamCheckInterval)
+	dur("DRAIN_PERIOD", &c.DrainPeriod)
+	dur("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	list("CORS_ALLOW_ORIGINS", &c.CORS.AllowOrigins)
+	list("CORS_ALLOW_METHODS", &c.CORS.AllowMethods)
+	list("CORS_ALLOW_HEADERS", &c.CORS.AllowHeaders)
+	list("CORS_EXPOSE_HEADERS", &c.CORS.ExposeHeaders)
+	boolean("CORS_ALLOW_CREDENTIALS", &c.CORS.AllowCredentials)
+	dur("CORS_MAX_AGE", &c.CORS.MaxAge)
+	str("AUTH_JWKS_URL", &c.Auth.JWKSURL)
+	str("AUTH_ISSUER", &c.Auth.Issuer)
+	str("AUTH_AUDIENCE", &c.Auth.Audience)
+	dur("AUTH_JWKS_REFRESH_INTERVAL", &c.Auth.RefreshInterval)
+	list("AUTH_EXEMPT_PATHS", &c.Auth.ExemptPaths)
+	str("SERVICE_AUTH_SECRET", &c.ServiceAuth.Secret)
+	list("SERVICE_AUTH_ALLOWED_CALLERS", &c.ServiceAuth.AllowedCallers)
+	c.ServiceAuth.CallerSecrets = map[string]string{}
+	for _, caller := range c.ServiceAuth.AllowedCallers {
+		if v, ok := os.LookupEnv(CallerSecretEnv(caller)); ok {
+			c.ServiceAuth.CallerSecrets[caller] = v
+		}
+	}
+	list("SERVICE_AUTH_PATHS", &c.ServiceAuth.Paths)
+	list("SERVICE_AUTH_EXEMPT_PATHS", &c.ServiceAuth.ExemptPaths)
+	dur("SERVICE_AUTH_MAX_SKEW", &c.ServiceAuth.MaxSkew)
+	float("RATE_LIMIT_IP_RPS", &c.RateLimit.IPRate)
+	num("RATE_LIMIT_IP_BURST", &c.RateLimit.IPBurst)
+	float("RATE_LIMIT_CLIENT_RPS", &c.RateLimit.ClientRate)
+	num("RATE_LIMIT_CLIENT_BURST", &c.RateLimit.ClientBurst)
+	float("RATE_LIMIT_GLOBAL_RPS", &c.RateLimit.GlobalRate)
+	num("RATE_LIMIT_GLOBAL_BURST", &c.RateLimit.GlobalBurst)
+	list("RATE_LIMIT_EXEMPT_PATHS", &c.RateLimit.ExemptPaths)
+
+	return errs
+}
+
+// service connect discovery names may contain underscores, e.g. server_service.local
+var hostname = regexp.MustCompile(`^([A-Za-z0-9_]([A-Za-z0-9_-]{0,61}[A-Za-z0-9_])?)(\.[A-Za-z0-9_]([A-Za-z0-9_-]{0,61}[A-Za-z0-9_])?)*$`)
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Za-z0-9]`)
+
+func (c *Config) Validate() []error {
+	var errs []error
+
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT=%d: must be between 1 and 65535", c.Port))
+	}
+	if c.ContainerName == "" {
+		errs = append(errs, errors.New("CONTAINER_NAME: must not be empty"))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL=%q: must be one of debug, info, warn, error", c.LogLevel))
+	}
+	if c.GRPCPort != 0 && (c.GRPCPort < 1 || c.GRPCPort > 65535 || c.GRPCPort == c.Port) {
+		errs = append(errs, fmt.Errorf("GRPC_PORT=%d: must be between 1 and 65535 and differ from PORT", c.GRPCPort))
+	}
+	if c.MetricsPort != 0 && (c.MetricsPort < 1 || c.MetricsPort > 65535 || c.MetricsPort == c.Port || c.MetricsPort == c.GRPCPort) {
+		errs = append(errs, fmt.Errorf("METRICS_PORT=%d: must be between 1 and 65535 and differ from PORT and GRPC_PORT", c.MetricsPort))
+	}
+
+	if c.UpstreamHost != "" {
+		if net.ParseIP(c.UpstreamHost) == nil && (len(c.UpstreamHost) > 253 || !hostname.MatchString(c.UpstreamHost)) {
+			errs = append(errs, fmt.Errorf("CONTAINER_HOST=%q: must be a hostname or an IP address", c.UpstreamHost))
+		}
+		if c.UpstreamPort < 1 || c.UpstreamPort > 65535 {
+			errs = append(errs, fmt.Errorf("CONTAINER_PORT=%d: must be between 1 and 65535", c.UpstreamPort))
+		}
+		if c.UpstreamProtocol != "http" && c.UpstreamProtocol != "grpc" {
+			errs = append(errs, fmt.Errorf("UPSTREAM_PROTOCOL=%q: must be http or grpc", c.UpstreamProtocol))
+		}
+	}
+
+	for _, v := range []struct {
+		key string
+		d   Duration
+	}{
+		{"UPSTREAM_TIMEOUT", c.UpstreamTimeout},
+		{"HEALTH_CHECK_TIMEOUT", c.HealthCheckTimeout},
+		{"UPSTREAM_CHECK_INTERVAL", c.UpstreamCheckInterval},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+	} {
+		if v.d.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("%s=%s: must be positive", v.key, v.d))
+		}
+	}
+	if c.DrainPeriod.Duration < 0 {
+		errs = append(errs, fmt.Errorf("DRAIN_PERIOD=%s: must not be negative", c.DrainPeriod))
+	}
+
+	for _, o := range c.CORS.AllowOrigins {
+		if o == "*" && c.CORS.AllowCredentials {
+			errs = append(errs, errors.New(`CORS_ALLOW_ORIGINS="*": cannot be combined with CORS_ALLOW_CREDENTIALS, list the origins instead`))
+		}
+		if _, err := path.Match(o, ""); err != nil || (o != "*" && !strings.Contains(o, "://")) {
+			errs = append(errs, fmt.Errorf("CORS_ALLOW_ORIGINS=%q: must be \"*\" or a scheme://host[:port] origin, optionally with * wildcards", o))
+		}
+	}
+	if c.CORS.MaxAge.Duration < 0 {
+		errs = append(errs, fmt.Errorf("CORS_MAX_AGE=%s: must not be negative", c.CORS.MaxAge))
+	}
+
+	if c.Auth.Enabled() {
+		if u, err := url.Parse(c.Auth.JWKSURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("AUTH_JWKS_URL=%q: must be an http(s) URL", c.Auth.JWKSURL))
+		}
+		if c.Auth.Issuer == "" {
+			errs = append(errs, errors.New("AUTH_ISSUER: must be set when AUTH_JWKS_URL is set"))
+		}
+		if c.Auth.Audience == "" {
+			errs = append(errs, errors.New("AUTH_AUDIENCE: must be set when AUTH_JWKS_URL is set"))
+		}
+		if c.Auth.RefreshInterval.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("AUTH_JWKS_REFRESH_INTERVAL=%s: must be positive", c.Auth.RefreshInterval))
+		}
+	}
+
+	if c.ServiceAuth.SigningEnabled() && len(c.ServiceAuth.Secret) < 32 {
+		errs = append(errs, errors.New("SERVICE_AUTH_SECRET: must be at least 32 characters"))
+	}
+	if c.ServiceAuth.VerificationEnabled() {
+		for _, caller := range c.ServiceAuth.AllowedCallers {
+			if len(c.ServiceAuth.CallerSecrets[caller]) < 32 {
+				errs = append(errs, fmt.Errorf("%s: must be at least 32 characters", CallerSecretEnv(caller)))
+			}
+		}
+		if len(c.ServiceAuth.Paths) == 0 {
+			errs = append(errs, errors.New("SERVICE_AUTH_PATHS: must not be empty when SERVICE_AUTH_ALLOWED_CALLERS is set"))
+		}
+		if c.ServiceAuth.MaxSkew.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("SERVICE_AUTH_MAX_SKEW=%s: must be positive", c.ServiceAuth.MaxSkew))
+		}
+	}
+
+	for _, l := range []struct {
+		name  string
+		rate  float64
+		burst int
+	}{
+		{"IP", c.RateLimit.IPRate, c.RateLimit.IPBurst},
+		{"CLIENT", c.RateLimit.ClientRate, c.RateLimit.ClientBurst},
+		{"GLOBAL", c.RateLimit.GlobalRate, c.RateLimit.GlobalBurst},
+	} {
+		if l.rate < 0 {
+			errs = append(errs, fmt.Errorf("RATE_LIMIT_%s_RPS=%g: must not be negative", l.name, l.rate))
+		}
+		if l.rate > 0 && l.burst < 1 {
+			errs = append(errs, fmt.Errorf("RATE_LIMIT_%s_BURST=%d: must be at least 1 when RATE_LIMIT_%s_RPS is set", l.name, l.burst, l.name))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,166 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sasaki-q/private/config"
+)
+
+const secret string = "0123456789abcdef0123456789abcdef"
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		// written to CONFIG_FILE when set
+		file string
+		// every one must appear in the error
+		wantErrs []string
+		check    func(t *testing.T, cfg config.Config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg config.Config) {
+				if cfg.Port != 8080 || cfg.UpstreamTimeout.Duration != 5*time.Second || cfg.ServiceAuth.MaxSkew.Duration != time.Minute {
+					t.Errorf("cfg = %+v, want the defaults", cfg)
+				}
+			},
+		},
+		{name: "non-numeric PORT", env: map[string]string{"PORT": "http"}, wantErrs: []string{`PORT="http": not a number`}},
+		{name: "PORT out of range", env: map[string]string{"PORT": "65536"}, wantErrs: []string{"PORT=65536: must be between 1 and 65535"}},
+		{name: "PORT zero", env: map[string]string{"PORT": "0"}, wantErrs: []string{"PORT=0: must be between 1 and 65535"}},
+		{name: "non-numeric GRPC_PORT", env: map[string]string{"GRPC_PORT": "grpc"}, wantErrs: []string{`GRPC_PORT="grpc": not a number`}},
+		{name: "GRPC_PORT out of range", env: map[string]string{"GRPC_PORT": "70000"}, wantErrs: []string{"GRPC_PORT=70000: must be between 1 and 65535 and differ from PORT"}},
+		{name: "GRPC_PORT equals PORT", env: map[string]string{"PORT": "9000", "GRPC_PORT": "9000"}, wantErrs: []string{"GRPC_PORT=9000"}},
+		{name: "METRICS_PORT equals GRPC_PORT", env: map[string]string{"GRPC_PORT": "9000", "METRICS_PORT": "9000"}, wantErrs: []string{"METRICS_PORT=9000"}},
+		{
+			name: "service connect host",
+			env:  map[string]string{"CONTAINER_HOST": "server_service.local", "CONTAINER_PORT": "80"},
+			check: func(t *testing.T, cfg config.Config) {
+				if got := cfg.UpstreamAddress(); got != "server_service.local:80" {
+					t.Errorf("UpstreamAddress = %q, want %q", got, "server_service.local:80")
+				}
+			},
+		},
+		{name: "IP host", env: map[string]string{"CONTAINER_HOST": "10.0.0.1", "CONTAINER_PORT": "80"}},
+		{name: "host with a space", env: map[string]string{"CONTAINER_HOST": "server service", "CONTAINER_PORT": "80"}, wantErrs: []string{`CONTAINER_HOST="server service": must be a hostname or an IP address`}},
+		{name: "host with a scheme", env: map[string]string{"CONTAINER_HOST": "http://server", "CONTAINER_PORT": "80"}, wantErrs: []string{"CONTAINER_HOST="}},
+		{name: "host with a trailing hyphen", env: map[string]string{"CONTAINER_HOST": "server-.local", "CONTAINER_PORT": "80"}, wantErrs: []string{"CONTAINER_HOST="}},
+		{name: "host without a port", env: map[string]string{"CONTAINER_HOST": "server"}, wantErrs: []string{"CONTAINER_PORT=0: must be between 1 and 65535"}},
+		{name: "unknown upstream protocol", env: map[string]string{"CONTAINER_HOST": "server", "CONTAINER_PORT": "80", "UPSTREAM_PROTOCOL": "tcp"}, wantErrs: []string{`UPSTREAM_PROTOCOL="tcp": must be http or grpc`}},
+		{name: "duration without a unit", env: map[string]string{"UPSTREAM_TIMEOUT": "5"}, wantErrs: []string{`UPSTREAM_TIMEOUT="5": not a duration`}},
+		{name: "zero duration", env: map[string]string{"SHUTDOWN_TIMEOUT": "0s"}, wantErrs: []string{"SHUTDOWN_TIMEOUT=0s: must be positive"}},
+		{name: "negative drain period", env: map[string]string{"DRAIN_PERIOD": "-1s"}, wantErrs: []string{"DRAIN_PERIOD=-1s: must not be negative"}},
+		{name: "no drain period", env: map[string]string{"DRAIN_PERIOD": "0s"}},
+		{
+			name: "every error",
+			env:  map[string]string{"PORT": "http", "GRPC_PORT": "70000", "HEALTH_CHECK_TIMEOUT": "soon", "LOG_LEVEL": "verbose"},
+			wantErrs: []string{
+				`PORT="http": not a number`,
+				"GRPC_PORT=70000",
+				`HEALTH_CHECK_TIMEOUT="soon": not a duration`,
+				`LOG_LEVEL="verbose"`,
+			},
+		},
+		{
+			name: "file overrides defaults",
+			file: `{"port": 9000, "upstream_timeout": "3s", "rate_limit": {"client_rate": 5, "client_burst": 10}}`,
+			check: func(t *testing.T, cfg config.Config) {
+				if cfg.Port != 9000 || cfg.UpstreamTimeout.Duration != 3*time.Second || cfg.RateLimit.ClientRate != 5 {
+					t.Errorf("cfg = %+v, want the file's values", cfg)
+				}
+				if cfg.HealthCheckTimeout.Duration != 2*time.Second {
+					t.Errorf("HealthCheckTimeout = %s, want the default 2s", cfg.HealthCheckTimeout)
+				}
+			},
+		},
+		{
+			name: "env overrides the file",
+			env:  map[string]string{"PORT": "9100", "UPSTREAM_TIMEOUT": "1s"},
+			file: `{"port": 9000, "upstream_timeout": "3s", "grpc_port": 9001}`,
+			check: func(t *testing.T, cfg config.Config) {
+				if cfg.Port != 9100 || cfg.UpstreamTimeout.Duration != time.Second {
+					t.Errorf("cfg = %+v, want the env's values", cfg)
+				}
+				if cfg.GRPCPort != 9001 {
+					t.Errorf("GRPCPort = %d, want the file's 9001", cfg.GRPCPort)
+				}
+			},
+		},
+		{name: "invalid file", file: `{"port": "9000"}`, wantErrs: []string{"CONFIG_FILE="}},
+		{name: "bad duration in the file", file: `{"drain_period": "15"}`, wantErrs: []string{"CONFIG_FILE="}},
+		{
+			name: "caller secrets",
+			env:  map[string]string{"SERVICE_AUTH_ALLOWED_CALLERS": "client_container", "SERVICE_AUTH_CALLER_SECRET_CLIENT_CONTAINER": secret},
+			check: func(t *testing.T, cfg config.Config) {
+				if got := cfg.ServiceAuth.CallerSecrets["client_container"]; got != secret {
+					t.Errorf("CallerSecrets[client_container] = %q, want %q", got, secret)
+				}
+			},
+		},
+		{
+			name:     "missing caller secret",
+			env:      map[string]string{"SERVICE_AUTH_ALLOWED_CALLERS": "client_container,billing", "SERVICE_AUTH_CALLER_SECRET_CLIENT_CONTAINER": secret},
+			wantErrs: []string{"SERVICE_AUTH_CALLER_SECRET_BILLING: must be at least 32 characters"},
+		},
+		{name: "IP rate without a burst", env: map[string]string{"RATE_LIMIT_IP_RPS": "5"}, wantErrs: []string{"RATE_LIMIT_IP_BURST=0: must be at least 1 when RATE_LIMIT_IP_RPS is set"}},
+		{name: "short secret", env: map[string]string{"SERVICE_AUTH_SECRET": "secret"}, wantErrs: []string{"SERVICE_AUTH_SECRET: must be at least 32 characters"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CONTAINER_NAME", "client")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "config.json")
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv("CONFIG_FILE", path)
+			}
+
+			cfg, err := config.Load()
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.check != nil {
+					tt.check(t, cfg)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("err = nil, want %q", tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("err = %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCallerSecretEnv(t *testing.T) {
+	tests := []struct {
+		caller string
+		want   string
+	}{
+		{caller: "client_container", want: "SERVICE_AUTH_CALLER_SECRET_CLIENT_CONTAINER"},
+		{caller: "billing-api.v2", want: "SERVICE_AUTH_CALLER_SECRET_BILLING_API_V2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.caller, func(t *testing.T) {
+			if got := config.CallerSecretEnv(tt.caller); got != tt.want {
+				t.Errorf("CallerSecretEnv(%q) = %q, want %q", tt.caller, got, tt.want)
+			}
+		})
+	}
+}
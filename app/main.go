@@ -1,76 +1,139 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
+	"log/slog"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/labstack/echo"
-	"github.com/labstack/echo/middleware"
-	"github.com/labstack/gommon/log"
+	"github.com/sasaki-q/private/auth"
+	"github.com/sasaki-q/private/config"
+	"github.com/sasaki-q/private/server"
 )
 
 func main() {
-	e := echo.New()
-	e.Logger.SetLevel(log.DEBUG)
-	e.Pre(middleware.RemoveTrailingSlash())
-	e.Use(middleware.Logger())
-	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
-		AllowMethods: []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE},
-	}))
-
-	e.GET("/hc", func(c echo.Context) error {
-		containerName := os.Getenv("CONTAINER_NAME")
-		return c.JSON(http.StatusOK, map[string]string{"message": containerName})
-	})
-
-	e.GET("/test", func(c echo.Context) error {
-		return c.JSON(http.StatusOK, map[string]string{"message": "test"})
-	})
-
-	e.GET("/connect", func(c echo.Context) error {
-		res, err := GetMessage()
+	cfg, err := config.Load()
+
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
 		if err != nil {
-			return c.String(http.StatusBadRequest, fmt.Sprintf("ERROR: %s\n", err))
+			os.Exit(1)
 		}
-		return c.JSON(http.StatusOK, map[string]string{
-			"message": fmt.Sprintf("FROM: %s container → TO: %s container", os.Getenv("CONTAINER_NAME"), *res),
-		})
-	})
+		os.Exit(server.RunHealthCheck(cfg.Port))
+	}
 
-	e.Logger.Fatal(e.Start(fmt.Sprintf(":%s", os.Getenv("PORT"))))
-}
+	slog.SetDefault(server.NewLogger(cfg.ContainerName, cfg.LogLevel))
+	if err != nil {
+		fatal("load config", err)
+	}
 
-func GetMessage() (*string, error) {
-	u, err := url.ParseRequestURI(fmt.Sprintf("http://%s:%s/hc", os.Getenv("CONTAINER_HOST"), os.Getenv("CONTAINER_PORT")))
+	shutdownTracing, err := server.InitTracing(context.Background(), cfg.ContainerName)
 	if err != nil {
-		log.Errorf("Error: Parse URI === %s", err)
-		return nil, err
+		fatal("init tracing", err)
+	}
+
+	var signer *auth.Signer
+	if cfg.ServiceAuth.SigningEnabled() {
+		signer = auth.NewSigner(cfg.ContainerName, cfg.ServiceAuth.Secret)
 	}
 
-	var tmp struct {
-		Message string `json:"message"`
+	var upstream server.Upstream
+	switch {
+	case cfg.UpstreamHost == "":
+	case cfg.UpstreamProtocol == "grpc":
+		u, err := server.NewGRPCUpstream(cfg.UpstreamAddress(), cfg.UpstreamTimeout.Duration)
+		if err != nil {
+			fatal("create grpc upstream", err)
+		}
+		defer u.Close()
+		u.Signer = signer
+		upstream = u
+	default:
+		u := server.NewHTTPUpstream(cfg.UpstreamAddress(), cfg.UpstreamTimeout.Duration)
+		u.Signer = signer
+		upstream = u
 	}
-	resp, err := http.Get(fmt.Sprintf("%v", u))
 
+	srv := server.New(cfg, upstream)
+
+	address := fmt.Sprintf(":%d", cfg.Port)
+	listener, err := net.Listen("tcp", address)
 	if err != nil {
-		log.Errorf("Error: Http Request === %s", err)
-		return nil, err
+		fatal("listen", err)
 	}
 
-	defer resp.Body.Close()
+	var grpcListener net.Listener
+	if srv.GRPC != nil {
+		grpcListener, err = net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort))
+		if err != nil {
+			fatal("listen grpc", err)
+		}
+	}
 
-	body, _ := io.ReadAll(resp.Body)
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	go srv.Health.MonitorUpstreams(monitorCtx, cfg.UpstreamCheckInterval.Duration)
 
-	if err := json.Unmarshal(body, &tmp); err != nil {
-		log.Errorf("Error: Unmarshal === %s", err)
-		return nil, err
+	var metricsListener net.Listener
+	if srv.Metrics != nil {
+		// the collector scrapes it from the same task
+		metricsListener, err = net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.MetricsPort))
+		if err != nil {
+			fatal("listen metrics", err)
+		}
+	}
+
+	srv.MarkStarted()
+	slog.Info("server started", slog.String("address", listener.Addr().String()))
+
+	go func() {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fatal("serve", err)
+		}
+	}()
+	if grpcListener != nil {
+		slog.Info("grpc server started", slog.String("address", grpcListener.Addr().String()))
+		go func() {
+			if err := srv.GRPC.Serve(grpcListener); err != nil {
+				fatal("serve grpc", err)
+			}
+		}()
+	}
+
+	if metricsListener != nil {
+		slog.Info("metrics server started", slog.String("address", metricsListener.Addr().String()))
+		go func() {
+			if err := srv.Metrics.Serve(metricsListener); err != nil && err != http.ErrServerClosed {
+				fatal("serve metrics", err)
+			}
+		}()
 	}
 
-	return &tmp.Message, nil
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM, os.Interrupt)
+	<-quit
+
+	// keep serving while the target group stops routing to this task
+	slog.Info("draining", slog.String("drain_period", cfg.DrainPeriod.String()))
+	srv.MarkDraining()
+	time.Sleep(cfg.DrainPeriod.Duration)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		fatal("shutdown", err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("shutdown tracing", slog.String("error", err.Error()))
+	}
+	slog.Info("server stopped")
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.String("error", err.Error()))
+	os.Exit(1)
 }
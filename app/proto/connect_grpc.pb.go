@@ -0,0 +1,152 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: connect.proto
+
+package connectpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	wrapperspb "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	ConnectService_Whoami_FullMethodName  = "/private.v1.ConnectService/Whoami"
+	ConnectService_Connect_FullMethodName = "/private.v1.ConnectService/Connect"
+)
+
+// ConnectServiceClient is the client API for ConnectService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ConnectServiceClient interface {
+	// Answers the container name, like GET /hc.
+	Whoami(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*wrapperspb.StringValue, error)
+	// Calls the upstream service, like GET /connect.
+	Connect(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*wrapperspb.StringValue, error)
+}
+
+type connectServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewConnectServiceClient(cc grpc.ClientConnInterface) ConnectServiceClient {
+	return &connectServiceClient{cc}
+}
+
+func (c *connectServiceClient) Whoami(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*wrapperspb.StringValue, error) {
+	out := new(wrapperspb.StringValue)
+	err := c.cc.Invoke(ctx, ConnectService_Whoami_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectServiceClient) Connect(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*wrapperspb.StringValue, error) {
+	out := new(wrapperspb.StringValue)
+	err := c.cc.Invoke(ctx, ConnectService_Connect_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ConnectServiceServer is the server API for ConnectService service.
+// All implementations must embed UnimplementedConnectServiceServer
+// for forward compatibility
+type ConnectServiceServer interface {
+	// Answers the container name, like GET /hc.
+	Whoami(context.Context, *emptypb.Empty) (*wrapperspb.StringValue, error)
+	// Calls the upstream service, like GET /connect.
+	Connect(context.Context, *emptypb.Empty) (*wrapperspb.StringValue, error)
+	mustEmbedUnimplementedConnectServiceServer()
+}
+
+// UnimplementedConnectServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedConnectServiceServer struct {
+}
+
+func (UnimplementedConnectServiceServer) Whoami(context.Context, *emptypb.Empty) (*wrapperspb.StringValue, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Whoami not implemented")
+}
+func (UnimplementedConnectServiceServer) Connect(context.Context, *emptypb.Empty) (*wrapperspb.StringValue, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Connect not implemented")
+}
+func (UnimplementedConnectServiceServer) mustEmbedUnimplementedConnectServiceServer() {}
+
+// UnsafeConnectServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ConnectServiceServer will
+// result in compilation errors.
+type UnsafeConnectServiceServer interface {
+	mustEmbedUnimplementedConnectServiceServer()
+}
+
+func RegisterConnectServiceServer(s grpc.ServiceRegistrar, srv ConnectServiceServer) {
+	s.RegisterService(&ConnectService_ServiceDesc, srv)
+}
+
+func _ConnectService_Whoami_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectServiceServer).Whoami(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ConnectService_Whoami_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectServiceServer).Whoami(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ConnectService_Connect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectServiceServer).Connect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ConnectService_Connect_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectServiceServer).Connect(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ConnectService_ServiceDesc is the grpc.ServiceDesc for ConnectService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ConnectService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "private.v1.ConnectService",
+	HandlerType: (*ConnectServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Whoami",
+			Handler:    _ConnectService_Whoami_Handler,
+		},
+		{
+			MethodName: "Connect",
+			Handler:    _ConnectService_Connect_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "connect.proto",
+}
@@ -0,0 +1,5 @@
+// Package connectpb holds the code generated from connect.proto.
+package connectpb
+
+// needs buf, protoc-gen-go and protoc-gen-go-grpc on PATH
+//go:generate buf generate
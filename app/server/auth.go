@@ -0,0 +1,58 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sasaki-q/private/auth"
+	"github.com/sasaki-q/private/config"
+)
+
+// SubjectContextKey holds the authenticated subject on the echo context.
+const SubjectContextKey string = "subject"
+
+// AuthMiddleware rejects requests without a valid bearer JWT, except on the
+// exempt paths used by health checks and metrics scrapers.
+func AuthMiddleware(cfg config.Auth, verifier *auth.Verifier) echo.MiddlewareFunc {
+	exempt := map[string]bool{}
+	for _, v := range cfg.ExemptPaths {
+		exempt[v] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if exempt[req.URL.Path] || req.Method == http.MethodOptions {
+				return next(c)
+			}
+
+			token, ok := bearerToken(req.Header.Get(echo.HeaderAuthorization))
+			if !ok {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer`)
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing bearer token")
+			}
+
+			claims, err := verifier.Verify(req.Context(), token)
+			if err != nil {
+				Logger(req.Context()).WarnContext(req.Context(), "invalid bearer token", slog.String("error", err.Error()))
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid bearer token")
+			}
+
+			c.Set(SubjectContextKey, claims.Subject)
+			c.SetRequest(req.WithContext(auth.WithClaims(req.Context(), claims)))
+
+			return next(c)
+		}
+	}
+}
+
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
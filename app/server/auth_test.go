@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sasaki-q/private/auth/authtest"
+)
+
+func TestAuth(t *testing.T) {
+	p := authtest.NewProvider(t)
+
+	cfg := newTestConfig()
+	cfg.Auth.JWKSURL = p.URL
+	cfg.Auth.Issuer = authtest.Issuer
+	cfg.Auth.Audience = authtest.Audience
+	srv := New(cfg, nil)
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "exempt health check", path: "/hc", wantStatus: http.StatusOK},
+		{name: "exempt liveness", path: "/livez", wantStatus: http.StatusOK},
+		{name: "missing token", path: "/test", wantStatus: http.StatusUnauthorized},
+		{name: "not a bearer token", path: "/test", authorization: "Basic dXNlcjpwYXNz", wantStatus: http.StatusUnauthorized},
+		{name: "invalid token", path: "/test", authorization: "Bearer not-a-jwt", wantStatus: http.StatusUnauthorized},
+		{name: "valid token", path: "/test", authorization: "Bearer " + p.Token(t, "user-1", nil), wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			srv.Echo.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("WWW-Authenticate header is missing")
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sasaki-q/private/config"
+)
+
+// CORSMiddleware replaces echo's CORS middleware, which only matches exact origins
+// or "*", to also accept wildcard patterns such as https://*.example.com.
+// Requests from origins that are not allowed get no CORS headers at all.
+func CORSMiddleware(cfg config.CORS) echo.MiddlewareFunc {
+	allowMethods := strings.Join(cfg.AllowMethods, ",")
+	allowHeaders := strings.Join(cfg.AllowHeaders, ",")
+	exposeHeaders := strings.Join(cfg.ExposeHeaders, ",")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if len(cfg.AllowOrigins) == 0 {
+				return next(c)
+			}
+
+			req := c.Request()
+			header := c.Response().Header()
+			origin := req.Header.Get(echo.HeaderOrigin)
+			preflight := req.Method == http.MethodOptions && req.Header.Get(echo.HeaderAccessControlRequestMethod) != ""
+
+			header.Add(echo.HeaderVary, echo.HeaderOrigin)
+			if preflight {
+				header.Add(echo.HeaderVary, echo.HeaderAccessControlRequestMethod)
+				header.Add(echo.HeaderVary, echo.HeaderAccessControlRequestHeaders)
+			}
+
+			allowOrigin, ok := matchOrigin(cfg, origin)
+			if !ok {
+				if preflight {
+					return c.NoContent(http.StatusNoContent)
+				}
+				return next(c)
+			}
+
+			header.Set(echo.HeaderAccessControlAllowOrigin, allowOrigin)
+			if cfg.AllowCredentials {
+				header.Set(echo.HeaderAccessControlAllowCredentials, "true")
+			}
+
+			if !preflight {
+				if exposeHeaders != "" {
+					header.Set(echo.HeaderAccessControlExposeHeaders, exposeHeaders)
+				}
+				return next(c)
+			}
+
+			header.Set(echo.HeaderAccessControlAllowMethods, allowMethods)
+			if allowHeaders != "" {
+				header.Set(echo.HeaderAccessControlAllowHeaders, allowHeaders)
+			}
+			if cfg.MaxAge.Duration > 0 {
+				header.Set(echo.HeaderAccessControlMaxAge, maxAge)
+			}
+			return c.NoContent(http.StatusNoContent)
+		}
+	}
+}
+
+// matchOrigin returns the value of Access-Control-Allow-Origin for origin.
+func matchOrigin(cfg config.CORS, origin string) (string, bool) {
+	if origin == "" {
+		return "", false
+	}
+
+	for _, o := range cfg.AllowOrigins {
+		if o == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(o, origin) {
+			return origin, true
+		}
+		if ok, _ := path.Match(strings.ToLower(o), strings.ToLower(origin)); ok {
+			return origin, true
+		}
+	}
+	return "", false
+}
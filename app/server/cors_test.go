@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sasaki-q/private/config"
+)
+
+func TestCORS(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.CORS.AllowOrigins = []string{"https://app.example.com", "https://*.preview.example.com"}
+	cfg.CORS.AllowCredentials = true
+	cfg.CORS.MaxAge = config.Duration{Duration: 10 * time.Minute}
+	srv := New(cfg, nil)
+
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		wantStatus int
+		wantOrigin string
+	}{
+		{name: "exact origin", method: http.MethodGet, origin: "https://app.example.com", wantStatus: http.StatusOK, wantOrigin: "https://app.example.com"},
+		{name: "wildcard origin", method: http.MethodGet, origin: "https://pr-1.preview.example.com", wantStatus: http.StatusOK, wantOrigin: "https://pr-1.preview.example.com"},
+		{name: "other origin", method: http.MethodGet, origin: "https://evil.example.org", wantStatus: http.StatusOK, wantOrigin: ""},
+		{name: "preflight", method: http.MethodOptions, origin: "https://app.example.com", wantStatus: http.StatusNoContent, wantOrigin: "https://app.example.com"},
+		{name: "preflight from other origin", method: http.MethodOptions, origin: "https://evil.example.org", wantStatus: http.StatusNoContent, wantOrigin: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/test", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			rec := httptest.NewRecorder()
+			srv.Echo.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if tt.method == http.MethodOptions && tt.wantOrigin != "" {
+				if got := rec.Header().Get("Access-Control-Max-Age"); got != "600" {
+					t.Errorf("Access-Control-Max-Age = %q, want %q", got, "600")
+				}
+				if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+					t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, "true")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sasaki-q/private/auth"
+	"github.com/sasaki-q/private/config"
+	connectpb "github.com/sasaki-q/private/proto"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// GRPCHandlers serves ConnectService, the gRPC counterpart of /hc and /connect
+// described in proto/connect.proto.
+type GRPCHandlers struct {
+	connectpb.UnimplementedConnectServiceServer
+	Config   config.Config
+	Upstream Upstream
+}
+
+func (h *GRPCHandlers) Whoami(context.Context, *emptypb.Empty) (*wrapperspb.StringValue, error) {
+	return wrapperspb.String(h.Config.ContainerName), nil
+}
+
+func (h *GRPCHandlers) Connect(ctx context.Context, _ *emptypb.Empty) (*wrapperspb.StringValue, error) {
+	if h.Upstream == nil {
+		return nil, status.Error(codes.Unavailable, "no upstream configured")
+	}
+
+	res, err := h.Upstream.GetMessage(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return wrapperspb.String(fmt.Sprintf("FROM: %s container → TO: %s container", h.Config.ContainerName, res)), nil
+}
+
+// newGRPCServer serves the standard health service next to ConnectService. Both
+// report NOT_SERVING until the server is marked started. The JWT and rate limit
+// middlewares are not applied, the port is only reachable through Service Connect.
+func newGRPCServer(cfg config.Config, upstream Upstream) (*grpc.Server, *health.Server) {
+	interceptors := []grpc.UnaryServerInterceptor{ObservabilityInterceptor()}
+	if cfg.ServiceAuth.VerificationEnabled() {
+		verifier := auth.NewServiceVerifier(cfg.ServiceAuth.CallerSecrets, cfg.ServiceAuth.MaxSkew.Duration)
+		interceptors = append(interceptors, ServiceAuthInterceptor(cfg.ServiceAuth, verifier))
+	}
+
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+
+	hs := health.NewServer()
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	hs.SetServingStatus(connectpb.ConnectService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+
+	connectpb.RegisterConnectServiceServer(s, &GRPCHandlers{Config: cfg, Upstream: upstream})
+
+	return s, hs
+}
+
+// ObservabilityInterceptor is the gRPC counterpart of the request id, logger,
+// tracing and metrics middlewares.
+func ObservabilityInterceptor() grpc.UnaryServerInterceptor {
+	tracer := otel.Tracer(TracerName)
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		id := metadataCarrier(md).Get(headerRequestID)
+		if id == "" {
+			id = newRequestID()
+		}
+		ctx = WithRequestID(ctx, id)
+		grpc.SetHeader(ctx, metadata.Pairs(headerRequestID, id))
+
+		service, method := splitMethod(info.FullMethod)
+		ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+		ctx, span := tracer.Start(ctx, strings.TrimPrefix(info.FullMethod, "/"),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.RPCSystemGRPC, semconv.RPCService(service), semconv.RPCMethod(method)),
+		)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+		span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+		if err != nil {
+			span.SetStatus(otelcodes.Error, err.Error())
+		}
+
+		grpcRequestsTotal.WithLabelValues(info.FullMethod, code.String()).Inc()
+		grpcRequestDuration.WithLabelValues(info.FullMethod, code.String()).Observe(time.Since(start).Seconds())
+
+		level := slog.LevelInfo
+		switch code {
+		case codes.OK:
+		case codes.Unknown, codes.Internal, codes.DataLoss:
+			level = slog.LevelError
+		default:
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("method", info.FullMethod),
+			slog.String("code", code.String()),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		Logger(ctx).LogAttrs(ctx, level, "rpc", attrs...)
+
+		return resp, err
+	}
+}
+
+// ServiceAuthInterceptor verifies the signature set by the calling GRPCUpstream,
+// a gRPC call being a POST to the method's path.
+func ServiceAuthInterceptor(cfg config.ServiceAuth, verifier *auth.ServiceVerifier) grpc.UnaryServerInterceptor {
+	protects := protectedPaths(cfg)
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !protects(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		r := &http.Request{Method: http.MethodPost, URL: &url.URL{Path: info.FullMethod}, Header: http.Header{}}
+		for k, v := range md {
+			r.Header[http.CanonicalHeaderKey(k)] = v
+		}
+
+		if _, err := verifier.Verify(r); err != nil {
+			Logger(ctx).WarnContext(ctx, "rejected service call",
+				slog.String("caller", r.Header.Get(auth.HeaderServiceIdentity)),
+				slog.String("error", err.Error()),
+			)
+			return nil, status.Error(codes.PermissionDenied, "caller is not allowed")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// gRPC metadata keys are lower case
+const headerRequestID string = "x-request-id"
+
+// metadataCarrier lets the otel propagator read and write gRPC metadata.
+type metadataCarrier metadata.MD
+
+func (m metadataCarrier) Get(key string) string {
+	if v := metadata.MD(m).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (m metadataCarrier) Set(key string, value string) {
+	metadata.MD(m).Set(key, value)
+}
+
+func (m metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func splitMethod(fullMethod string) (string, string) {
+	service, method, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	return service, method
+}
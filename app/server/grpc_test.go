@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sasaki-q/private/auth"
+	"github.com/sasaki-q/private/config"
+	connectpb "github.com/sasaki-q/private/proto"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// newTestGRPCServer serves srv.GRPC and returns its address.
+func newTestGRPCServer(t *testing.T, cfg config.Config, upstream Upstream) (*Server, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.GRPCPort = 1
+	srv := New(cfg, upstream)
+	go srv.GRPC.Serve(listener)
+	t.Cleanup(srv.GRPC.Stop)
+	return srv, listener.Addr().String()
+}
+
+func newTestGRPCUpstream(t *testing.T, address string) *GRPCUpstream {
+	t.Helper()
+	u, err := NewGRPCUpstream(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { u.Close() })
+	return u
+}
+
+func TestGRPCConnect(t *testing.T) {
+	serverCfg := newTestConfig()
+	serverCfg.ContainerName = "server"
+	_, serverAddress := newTestGRPCServer(t, serverCfg, nil)
+	_, clientAddress := newTestGRPCServer(t, newTestConfig(), newTestGRPCUpstream(t, serverAddress))
+
+	res, err := connectpb.NewConnectServiceClient(newTestGRPCUpstream(t, clientAddress).conn).Connect(context.Background(), &emptypb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "FROM: client container → TO: server container"; res.GetValue() != want {
+		t.Errorf("message = %q, want %q", res.GetValue(), want)
+	}
+
+	// and over HTTP, /connect goes through the same upstream
+	rec := get(t, New(newTestConfig(), newTestGRPCUpstream(t, serverAddress)), "/connect")
+	if got := decodeMessage(t, rec); got != "FROM: client container → TO: server container" {
+		t.Errorf("/connect message = %q", got)
+	}
+}
+
+func TestGRPCConnectWithoutUpstream(t *testing.T) {
+	_, address := newTestGRPCServer(t, newTestConfig(), nil)
+
+	_, err := connectpb.NewConnectServiceClient(newTestGRPCUpstream(t, address).conn).Connect(context.Background(), &emptypb.Empty{})
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Errorf("code = %s, want %s", code, codes.Unavailable)
+	}
+}
+
+func TestGRPCHealth(t *testing.T) {
+	srv, address := newTestGRPCServer(t, newTestConfig(), nil)
+	client := healthpb.NewHealthClient(newTestGRPCUpstream(t, address).conn)
+
+	check := func() healthpb.HealthCheckResponse_ServingStatus {
+		t.Helper()
+		res, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: connectpb.ConnectService_ServiceDesc.ServiceName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.GetStatus()
+	}
+
+	if got := check(); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("before start: status = %s, want NOT_SERVING", got)
+	}
+	srv.MarkStarted()
+	if got := check(); got != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("after start: status = %s, want SERVING", got)
+	}
+	srv.MarkDraining()
+	if got := check(); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("draining: status = %s, want NOT_SERVING", got)
+	}
+}
+
+func TestGRPCServiceAuth(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.ContainerName = "server"
+	cfg.ServiceAuth.AllowedCallers = []string{"client", "billing"}
+	cfg.ServiceAuth.CallerSecrets = map[string]string{"client": testSecret, "billing": otherSecret}
+	_, address := newTestGRPCServer(t, cfg, nil)
+
+	tests := []struct {
+		name     string
+		signer   *auth.Signer
+		wantCode codes.Code
+	}{
+		{name: "declared caller", signer: auth.NewSigner("client", testSecret), wantCode: codes.OK},
+		{name: "undeclared caller", signer: auth.NewSigner("other", testSecret), wantCode: codes.PermissionDenied},
+		{name: "declared caller signing as another", signer: auth.NewSigner("client", otherSecret), wantCode: codes.PermissionDenied},
+		{name: "unsigned", wantCode: codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newTestGRPCUpstream(t, address)
+			upstream.Signer = tt.signer
+
+			_, err := upstream.GetMessage(context.Background())
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("code = %s, want %s", code, tt.wantCode)
+			}
+		})
+	}
+
+	// the health service stays reachable without a signature
+	if err := newTestGRPCUpstream(t, address).Ping(context.Background()); err != nil {
+		t.Errorf("ping: %s", err)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sasaki-q/private/auth"
+	connectpb "github.com/sasaki-q/private/proto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// GRPCUpstream calls the upstream's ConnectService. Service Connect terminates the
+// connection in the local proxy, which retries and reports metrics per call.
+type GRPCUpstream struct {
+	Address string
+	Timeout time.Duration
+	// optional, signs calls for the upstream's ServiceAuthInterceptor
+	Signer *auth.Signer
+
+	conn *grpc.ClientConn
+}
+
+// NewGRPCUpstream does not connect, the connection is established on the first call.
+func NewGRPCUpstream(address string, timeout time.Duration) (*GRPCUpstream, error) {
+	u := &GRPCUpstream{Address: address, Timeout: timeout}
+
+	conn, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(u.intercept),
+	)
+	if err != nil {
+		return nil, err
+	}
+	u.conn = conn
+
+	return u, nil
+}
+
+func (u *GRPCUpstream) Target() string {
+	return u.Address
+}
+
+func (u *GRPCUpstream) Close() error {
+	return u.conn.Close()
+}
+
+func (u *GRPCUpstream) GetMessage(ctx context.Context) (string, error) {
+	target := u.Target()
+	upstreamRequestsTotal.WithLabelValues(target).Inc()
+	start := time.Now()
+	defer func() { upstreamRequestDuration.WithLabelValues(target).Observe(time.Since(start).Seconds()) }()
+
+	service, method := splitMethod(connectpb.ConnectService_Whoami_FullMethodName)
+	ctx, span := otel.Tracer(TracerName).Start(ctx, strings.TrimPrefix(connectpb.ConnectService_Whoami_FullMethodName, "/"),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.RPCSystemGRPC, semconv.RPCService(service), semconv.RPCMethod(method), semconv.ServerAddress(target)),
+	)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	res, err := connectpb.NewConnectServiceClient(u.conn).Whoami(ctx, &emptypb.Empty{})
+	code := status.Code(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+	if err != nil {
+		Logger(ctx).ErrorContext(ctx, "upstream request",
+			slog.String("upstream", target),
+			slog.String("code", code.String()),
+			slog.String("error", err.Error()),
+		)
+		upstreamErrorsTotal.WithLabelValues(target, "request").Inc()
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+
+	return res.GetValue(), nil
+}
+
+// Ping only checks that the upstream answers, like /livez, whatever its serving status.
+func (u *GRPCUpstream) Ping(ctx context.Context) error {
+	_, err := healthpb.NewHealthClient(u.conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+// intercept propagates the request id and trace context, and signs the call.
+func (u *GRPCUpstream) intercept(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+	if id := RequestIDFrom(ctx); id != "" {
+		md.Set(headerRequestID, id)
+	}
+	if u.Signer != nil {
+		r := &http.Request{Method: http.MethodPost, URL: &url.URL{Path: method}, Header: http.Header{}}
+		u.Signer.Sign(r)
+		for k := range r.Header {
+			md.Set(k, r.Header.Get(k))
+		}
+	}
+
+	return invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sasaki-q/private/config"
+)
+
+type Handlers struct {
+	Config   config.Config
+	Upstream Upstream
+}
+
+func (h *Handlers) Hc(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"message": h.Config.ContainerName})
+}
+
+func (h *Handlers) Test(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"message": "test"})
+}
+
+func (h *Handlers) Connect(c echo.Context) error {
+	if h.Upstream == nil {
+		return c.String(http.StatusServiceUnavailable, "ERROR: no upstream configured\n")
+	}
+
+	c.Set(UpstreamContextKey, h.Upstream.Target())
+
+	res, err := h.Upstream.GetMessage(c.Request().Context())
+	if err != nil {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("ERROR: %s\n", err))
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": fmt.Sprintf("FROM: %s container → TO: %s container", h.Config.ContainerName, res),
+	})
+}
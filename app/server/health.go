@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	StatusOK   string = "ok"
+	StatusFail string = "fail"
+)
+
+type Check struct {
+	Name string
+	Fn   func(ctx context.Context) error
+}
+
+// CheckResult is served to anyone reaching /readyz, the error of a failed check
+// is logged instead.
+type CheckResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Name   string                 `json:"name"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+type Health struct {
+	Name    string
+	Timeout time.Duration
+	// readiness checks besides startup and draining, they must not depend on
+	// another service, see Upstreams
+	Checks []Check
+	// not part of readiness: an upstream outage would take every caller out of
+	// the target group, MonitorUpstreams reports them in logs and metrics instead
+	Upstreams []Upstream
+
+	started  atomic.Bool
+	draining atomic.Bool
+}
+
+func (h *Health) MarkStarted()  { h.started.Store(true) }
+func (h *Health) MarkDraining() { h.draining.Store(true) }
+
+// Livez only reports that the process is able to serve requests.
+func (h *Health) Livez(c echo.Context) error {
+	return c.JSON(http.StatusOK, HealthReport{Status: StatusOK, Name: h.Name})
+}
+
+// Readyz reports whether the task should receive traffic: startup has completed
+// and the task is not draining.
+func (h *Health) Readyz(c echo.Context) error {
+	report := HealthReport{Status: StatusOK, Name: h.Name, Checks: map[string]CheckResult{}}
+
+	checks := append([]Check{
+		{Name: "startup", Fn: func(context.Context) error {
+			if !h.started.Load() {
+				return errors.New("startup has not completed")
+			}
+			return nil
+		}},
+		{Name: "draining", Fn: func(context.Context) error {
+			if h.draining.Load() {
+				return errors.New("server is draining")
+			}
+			return nil
+		}},
+	}, h.Checks...)
+
+	for _, v := range checks {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), h.Timeout)
+		start := time.Now()
+		err := v.Fn(ctx)
+		cancel()
+
+		result := CheckResult{Status: StatusOK, Latency: time.Since(start).String()}
+		if err != nil {
+			result.Status = StatusFail
+			report.Status = StatusFail
+			Logger(c.Request().Context()).Info("readiness check failed", slog.String("check", v.Name), slog.String("error", err.Error()))
+		}
+		report.Checks[v.Name] = result
+	}
+
+	if report.Status != StatusOK {
+		return c.JSON(http.StatusServiceUnavailable, report)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// MonitorUpstreams pings every upstream each interval until ctx is done, sets
+// upstream_up and logs when an upstream goes down or comes back.
+func (h *Health) MonitorUpstreams(ctx context.Context, interval time.Duration) {
+	up := map[string]bool{}
+	ping := func() {
+		for _, u := range h.Upstreams {
+			pingCtx, cancel := context.WithTimeout(ctx, h.Timeout)
+			err := u.Ping(pingCtx)
+			cancel()
+
+			target := u.Target()
+			was, seen := up[target]
+			up[target] = err == nil
+			if err != nil {
+				upstreamUp.WithLabelValues(target).Set(0)
+				if was || !seen {
+					slog.WarnContext(ctx, "upstream is down", slog.String("target", target), slog.String("error", err.Error()))
+				}
+				continue
+			}
+			upstreamUp.WithLabelValues(target).Set(1)
+			if !was && seen {
+				slog.InfoContext(ctx, "upstream is up", slog.String("target", target))
+			}
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ping()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunHealthCheck is used as the ECS container health check command, since the
+// production image has no curl or wget.
+func RunHealthCheck(port int) int {
+	client := http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/livez", port))
+	if err != nil {
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 1
+	}
+	return 0
+}
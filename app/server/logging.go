@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// handlers calling an upstream service set it on the echo context so the request log names it
+const UpstreamContextKey string = "upstream"
+
+type requestIDKey struct{}
+
+// NewLogger writes one JSON object per line to stdout, which the awslogs driver
+// ships to the service's log group as is.
+func NewLogger(containerName string, level string) *slog.Logger {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		l = slog.LevelInfo
+	}
+
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: l})).
+		With(slog.String("container", containerName))
+}
+
+// Logger returns the default logger annotated with the request and trace ids found in ctx.
+func Logger(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if id := RequestIDFrom(ctx); id != "" {
+		logger = logger.With(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		logger = logger.With(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return logger
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDMiddleware reuses the caller's X-Request-Id, or generates one, so the
+// same id shows up in the logs of every service a request goes through.
+func RequestIDMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			id := req.Header.Get(echo.HeaderXRequestID)
+			if id == "" {
+				id = newRequestID()
+			}
+
+			c.Response().Header().Set(echo.HeaderXRequestID, id)
+			c.SetRequest(req.WithContext(WithRequestID(req.Context(), id)))
+
+			return next(c)
+		}
+	}
+}
+
+func LoggerMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			req := c.Request()
+			status := statusOf(c, err)
+
+			level := slog.LevelInfo
+			switch {
+			case status >= 500:
+				level = slog.LevelError
+			case status >= 400:
+				level = slog.LevelWarn
+			}
+
+			attrs := []slog.Attr{
+				slog.String("method", req.Method),
+				slog.String("route", routeOf(c)),
+				slog.String("path", req.URL.Path),
+				slog.Int("status", status),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("remote_ip", c.RealIP()),
+			}
+			if upstream, ok := c.Get(UpstreamContextKey).(string); ok {
+				attrs = append(attrs, slog.String("upstream", upstream))
+			}
+			if err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+			}
+			Logger(req.Context()).LogAttrs(req.Context(), level, "request", attrs...)
+
+			return err
+		}
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
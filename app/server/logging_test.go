@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// captureLogs points the default logger at a buffer until the test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestLoggerMiddleware(t *testing.T) {
+	useTestTracing(t)
+
+	// in the order New installs them
+	e := echo.New()
+	e.Use(RequestIDMiddleware())
+	e.Use(LoggerMiddleware())
+	e.Use(TracingMiddleware())
+	e.GET("/users/:id", func(c echo.Context) error { return c.NoContent(http.StatusNoContent) })
+
+	tests := []struct {
+		name          string
+		headers       map[string]string
+		wantRequestID string
+		wantTraceID   string
+	}{
+		{
+			name:          "caller's ids",
+			headers:       map[string]string{echo.HeaderXRequestID: "req-1", "traceparent": testTraceparent},
+			wantRequestID: "req-1",
+			wantTraceID:   testTraceID,
+		},
+		{name: "generated ids"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			var line struct {
+				Msg       string `json:"msg"`
+				Route     string `json:"route"`
+				Status    int    `json:"status"`
+				RequestID string `json:"request_id"`
+				TraceID   string `json:"trace_id"`
+			}
+			if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+				t.Fatalf("unmarshal %q: %s", logs.String(), err)
+			}
+			if line.Msg != "request" || line.Route != "/users/:id" || line.Status != http.StatusNoContent {
+				t.Errorf("log = %s, want the request to /users/:id", logs.String())
+			}
+
+			// the id in the log is the one returned to the caller
+			if want := rec.Header().Get(echo.HeaderXRequestID); line.RequestID != want || want == "" {
+				t.Errorf("request_id = %q, want the response's %q", line.RequestID, want)
+			}
+			if tt.wantRequestID != "" && line.RequestID != tt.wantRequestID {
+				t.Errorf("request_id = %q, want %q", line.RequestID, tt.wantRequestID)
+			}
+			if len(line.TraceID) != 32 {
+				t.Errorf("trace_id = %q, want a trace id", line.TraceID)
+			}
+			if tt.wantTraceID != "" && line.TraceID != tt.wantTraceID {
+				t.Errorf("trace_id = %q, want %q", line.TraceID, tt.wantTraceID)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// go runtime and process metrics come from the collectors registered on the default registry
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests handled, by route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of HTTP requests handled, by route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	upstreamRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "upstream_requests_total",
+		Help: "Number of requests sent to an upstream service, by target.",
+	}, []string{"target"})
+
+	upstreamRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "upstream_request_duration_seconds",
+		Help:    "Latency of requests sent to an upstream service, by target.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"target"})
+
+	upstreamUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "upstream_up",
+		Help: "Whether the last ping of an upstream service succeeded, by target.",
+	}, []string{"target"})
+
+	upstreamErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "upstream_errors_total",
+		Help: "Number of failed requests to an upstream service, by target and reason.",
+	}, []string{"target", "reason"})
+
+	grpcRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_requests_total",
+		Help: "Number of gRPC calls handled, by method and code.",
+	}, []string{"method", "code"})
+
+	grpcRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_request_duration_seconds",
+		Help:    "Latency of gRPC calls handled, by method and code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	rateLimitedRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limited_requests_total",
+		Help: "Number of requests rejected by the rate limiter, by scope and route.",
+	}, []string{"scope", "route"})
+)
+
+func MetricsMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			labels := prometheus.Labels{"method": c.Request().Method, "route": routeOf(c), "status": strconv.Itoa(statusOf(c, err))}
+			httpRequestsTotal.With(labels).Inc()
+			httpRequestDuration.With(labels).Observe(time.Since(start).Seconds())
+
+			return err
+		}
+	}
+}
+
+// the error handler has not written the response yet when middleware sees the error
+func statusOf(c echo.Context, err error) int {
+	if err == nil {
+		return c.Response().Status
+	}
+	if he, ok := err.(*echo.HTTPError); ok {
+		return he.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// use the route pattern, not the raw path, to keep label cardinality bounded
+func routeOf(c echo.Context) string {
+	if c.Path() == "" {
+		return "unmatched"
+	}
+	return c.Path()
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// observations returns the number of samples the histogram has seen for labels.
+func observations(t *testing.T, vec *prometheus.HistogramVec, labels ...string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := vec.WithLabelValues(labels...).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// routeLabels returns the route label values of every series vec has.
+func routeLabels(t *testing.T, vec prometheus.Collector) map[string]bool {
+	t.Helper()
+	ch := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(ch)
+		close(ch)
+	}()
+
+	routes := map[string]bool{}
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "route" {
+				routes[l.GetValue()] = true
+			}
+		}
+	}
+	return routes
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Use(MetricsMiddleware())
+	e.GET("/metrics-test/:id", func(c echo.Context) error { return c.NoContent(http.StatusNoContent) })
+	e.GET("/metrics-test/fail", func(c echo.Context) error { return echo.ErrServiceUnavailable })
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		labels []string
+	}{
+		// every id counts towards the route pattern, not its own path
+		{name: "route", method: http.MethodGet, path: "/metrics-test/1", labels: []string{http.MethodGet, "/metrics-test/:id", "204"}},
+		{name: "same route", method: http.MethodGet, path: "/metrics-test/2", labels: []string{http.MethodGet, "/metrics-test/:id", "204"}},
+		{name: "error", method: http.MethodGet, path: "/metrics-test/fail", labels: []string{http.MethodGet, "/metrics-test/fail", "503"}},
+		// scanners probing random paths all count towards one series
+		{name: "unmatched", method: http.MethodGet, path: "/wp-login.php", labels: []string{http.MethodGet, "unmatched", "404"}},
+		{name: "other unmatched", method: http.MethodGet, path: "/.env", labels: []string{http.MethodGet, "unmatched", "404"}},
+		// echo matches the route before it checks the method
+		{name: "unmatched method", method: http.MethodDelete, path: "/metrics-test/1", labels: []string{http.MethodDelete, "/metrics-test/:id", "405"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count := testutil.ToFloat64(httpRequestsTotal.WithLabelValues(tt.labels...))
+			observed := observations(t, httpRequestDuration, tt.labels...)
+
+			e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+
+			if got := testutil.ToFloat64(httpRequestsTotal.WithLabelValues(tt.labels...)); got != count+1 {
+				t.Errorf("http_requests_total%v = %g, want %g", tt.labels, got, count+1)
+			}
+			if got := observations(t, httpRequestDuration, tt.labels...); got != observed+1 {
+				t.Errorf("http_request_duration_seconds%v count = %d, want %d", tt.labels, got, observed+1)
+			}
+		})
+	}
+
+	// the raw paths never become label values
+	routes := routeLabels(t, httpRequestsTotal)
+	for _, path := range []string{"/metrics-test/1", "/metrics-test/2", "/wp-login.php", "/.env"} {
+		if routes[path] {
+			t.Errorf("http_requests_total has a series for route %q", path)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sasaki-q/private/config"
+	"golang.org/x/time/rate"
+)
+
+// how often idle client buckets are dropped
+const rateLimitSweepInterval time.Duration = time.Minute
+
+// RateLimitMiddleware answers 429 with Retry-After once a client, or all clients
+// together, go over the configured rate. It runs after the auth middlewares so
+// authenticated requests are keyed by subject instead of IP.
+func RateLimitMiddleware(cfg config.RateLimit) echo.MiddlewareFunc {
+	var limiters []scopedLimiter
+	if cfg.ClientRate > 0 {
+		clients := newClientLimiters(rate.Limit(cfg.ClientRate), cfg.ClientBurst)
+		limiters = append(limiters, scopedLimiter{scope: "client", get: func(c echo.Context, now time.Time) *rate.Limiter {
+			return clients.get(clientKey(c), now)
+		}})
+	}
+	if cfg.GlobalRate > 0 {
+		global := rate.NewLimiter(rate.Limit(cfg.GlobalRate), cfg.GlobalBurst)
+		limiters = append(limiters, scopedLimiter{scope: "global", get: func(echo.Context, time.Time) *rate.Limiter {
+			return global
+		}})
+	}
+	return rateLimitMiddleware(cfg.ExemptPaths, limiters)
+}
+
+// IPRateLimitMiddleware answers 429 once a client IP goes over IPRate. It runs
+// before the auth middlewares, so a flood of requests they would reject is cut
+// short before it costs a token verification each.
+func IPRateLimitMiddleware(cfg config.RateLimit) echo.MiddlewareFunc {
+	ips := newClientLimiters(rate.Limit(cfg.IPRate), cfg.IPBurst)
+	return rateLimitMiddleware(cfg.ExemptPaths, []scopedLimiter{{scope: "ip", get: func(c echo.Context, now time.Time) *rate.Limiter {
+		return ips.get(ipKey(c), now)
+	}}})
+}
+
+type scopedLimiter struct {
+	scope string
+	get   func(c echo.Context, now time.Time) *rate.Limiter
+}
+
+func rateLimitMiddleware(exemptPaths []string, limiters []scopedLimiter) echo.MiddlewareFunc {
+	exempt := map[string]bool{}
+	for _, v := range exemptPaths {
+		exempt[v] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if exempt[req.URL.Path] {
+				return next(c)
+			}
+
+			now := time.Now()
+			var reservations []*rate.Reservation
+			scope, delay := "", time.Duration(0)
+			for _, v := range limiters {
+				r := v.get(c, now).ReserveN(now, 1)
+				reservations = append(reservations, r)
+				if d := r.DelayFrom(now); d > delay {
+					scope, delay = v.scope, d
+				}
+			}
+			if delay == 0 {
+				return next(c)
+			}
+
+			// a rejected request must not use up tokens for the next one
+			for _, r := range reservations {
+				r.CancelAt(now)
+			}
+
+			rateLimitedRequestsTotal.WithLabelValues(scope, routeOf(c)).Inc()
+			Logger(req.Context()).WarnContext(req.Context(), "rate limited",
+				slog.String("scope", scope),
+				slog.String("client", clientKey(c)),
+			)
+			c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter(delay)))
+			return echo.NewHTTPError(http.StatusTooManyRequests, "too many requests")
+		}
+	}
+}
+
+// clientKey prefers the authenticated identity, then the client IP.
+func clientKey(c echo.Context) string {
+	if v, ok := c.Get(SubjectContextKey).(string); ok && v != "" {
+		return "sub:" + v
+	}
+	if v, ok := c.Get(CallerContextKey).(string); ok && v != "" {
+		return "caller:" + v
+	}
+	return ipKey(c)
+}
+
+// ipKey is the client IP the ALB appended to X-Forwarded-For. Earlier entries are
+// set by the client and cannot be trusted.
+func ipKey(c echo.Context) string {
+	if xff := c.Request().Header.Get(echo.HeaderXForwardedFor); xff != "" {
+		parts := strings.Split(xff, ",")
+		if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+			return "ip:" + ip
+		}
+	}
+	return "ip:" + c.RealIP()
+}
+
+// a reservation that can never be satisfied reports InfDuration
+func retryAfter(delay time.Duration) int {
+	if delay == rate.InfDuration {
+		return 1
+	}
+	return int(math.Max(1, math.Ceil(delay.Seconds())))
+}
+
+type clientLimiters struct {
+	limit rate.Limit
+	burst int
+	// a bucket left alone this long is full again, dropping it changes nothing
+	idle time.Duration
+
+	mu        sync.Mutex
+	limiters  map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+func newClientLimiters(limit rate.Limit, burst int) *clientLimiters {
+	idle := time.Duration(float64(burst) / float64(limit) * float64(time.Second))
+	if idle < rateLimitSweepInterval {
+		idle = rateLimitSweepInterval
+	}
+	return &clientLimiters{
+		limit:     limit,
+		burst:     burst,
+		idle:      idle,
+		limiters:  map[string]*clientLimiter{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *clientLimiters) get(key string, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		for k, v := range l.limiters {
+			if now.Sub(v.lastSeen) > l.idle {
+				delete(l.limiters, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	v, ok := l.limiters[key]
+	if !ok {
+		v = &clientLimiter{Limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = v
+	}
+	v.lastSeen = now
+	return v.Limiter
+}
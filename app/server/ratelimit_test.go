@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sasaki-q/private/auth/authtest"
+)
+
+func TestRateLimit(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.RateLimit.ClientRate = 0.001
+	cfg.RateLimit.ClientBurst = 2
+	cfg.RateLimit.GlobalRate = 0.001
+	cfg.RateLimit.GlobalBurst = 3
+	srv := New(cfg, nil)
+
+	tests := []struct {
+		name       string
+		path       string
+		xff        string
+		wantStatus int
+	}{
+		{name: "first request", path: "/test", xff: "10.0.0.1", wantStatus: http.StatusOK},
+		{name: "within client burst", path: "/test", xff: "10.0.0.1", wantStatus: http.StatusOK},
+		{name: "over client burst", path: "/test", xff: "10.0.0.1", wantStatus: http.StatusTooManyRequests},
+		// only the entry appended by the ALB identifies the client
+		{name: "spoofed forwarded for", path: "/test", xff: "10.0.0.9, 10.0.0.1", wantStatus: http.StatusTooManyRequests},
+		{name: "exempt path", path: "/livez", xff: "10.0.0.1", wantStatus: http.StatusOK},
+		{name: "other client", path: "/test", xff: "10.0.0.2", wantStatus: http.StatusOK},
+		{name: "over global burst", path: "/test", xff: "10.0.0.3", wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("X-Forwarded-For", tt.xff)
+			rec := httptest.NewRecorder()
+			srv.Echo.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+				t.Error("Retry-After is not set")
+			}
+		})
+	}
+}
+
+// the IP limit runs before authentication, a client sending bad tokens is limited
+// too and the per-identity limit still applies after it
+func TestIPRateLimitBeforeAuth(t *testing.T) {
+	p := authtest.NewProvider(t)
+
+	cfg := newTestConfig()
+	cfg.Auth.JWKSURL = p.URL
+	cfg.Auth.Issuer = authtest.Issuer
+	cfg.Auth.Audience = authtest.Audience
+	cfg.RateLimit.IPRate = 0.001
+	cfg.RateLimit.IPBurst = 3
+	cfg.RateLimit.ClientRate = 0.001
+	cfg.RateLimit.ClientBurst = 1
+	srv := New(cfg, nil)
+	token := p.Token(t, "user-1", nil)
+
+	tests := []struct {
+		name          string
+		xff           string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "invalid token", xff: "10.0.0.1", authorization: "Bearer not-a-jwt", wantStatus: http.StatusUnauthorized},
+		{name: "invalid token again", xff: "10.0.0.1", authorization: "Bearer not-a-jwt", wantStatus: http.StatusUnauthorized},
+		{name: "missing token", xff: "10.0.0.1", wantStatus: http.StatusUnauthorized},
+		{name: "over IP burst", xff: "10.0.0.1", authorization: "Bearer not-a-jwt", wantStatus: http.StatusTooManyRequests},
+		{name: "valid token over IP burst", xff: "10.0.0.1", authorization: "Bearer " + token, wantStatus: http.StatusTooManyRequests},
+		{name: "other IP", xff: "10.0.0.2", authorization: "Bearer " + token, wantStatus: http.StatusOK},
+		{name: "same subject from another IP", xff: "10.0.0.3", authorization: "Bearer " + token, wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("X-Forwarded-For", tt.xff)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			srv.Echo.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sasaki-q/private/auth"
+	"github.com/sasaki-q/private/config"
+	connectpb "github.com/sasaki-q/private/proto"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type Server struct {
+	Echo   *echo.Echo
+	Health *Health
+	// nil unless GRPCPort is set
+	GRPC       *grpc.Server
+	GRPCHealth *health.Server
+	// nil unless MetricsPort is set, /metrics is not served on the public port
+	Metrics *http.Server
+
+	// the requests on Echo's server, including the h2c connections it hands over
+	// to http2 and no longer tracks
+	handlers sync.WaitGroup
+}
+
+// New builds the router. upstream is nil for services that do not call another
+// service, /connect then answers 503.
+func New(cfg config.Config, upstream Upstream) *Server {
+	health := &Health{Name: cfg.ContainerName, Timeout: cfg.HealthCheckTimeout.Duration}
+	if upstream != nil {
+		health.Upstreams = append(health.Upstreams, upstream)
+	}
+
+	h := &Handlers{Config: cfg, Upstream: upstream}
+
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+
+	e.Pre(middleware.RemoveTrailingSlash())
+	e.Use(RequestIDMiddleware())
+	e.Use(LoggerMiddleware())
+	e.Use(TracingMiddleware())
+	e.Use(MetricsMiddleware())
+	e.Use(CORSMiddleware(cfg.CORS))
+	if cfg.RateLimit.IPEnabled() {
+		e.Use(IPRateLimitMiddleware(cfg.RateLimit))
+	}
+	if cfg.Auth.Enabled() {
+		verifier := auth.NewVerifier(auth.NewJWKS(cfg.Auth.JWKSURL, cfg.Auth.RefreshInterval.Duration), cfg.Auth.Issuer, cfg.Auth.Audience)
+		e.Use(AuthMiddleware(cfg.Auth, verifier))
+	}
+	if cfg.ServiceAuth.VerificationEnabled() {
+		verifier := auth.NewServiceVerifier(cfg.ServiceAuth.CallerSecrets, cfg.ServiceAuth.MaxSkew.Duration)
+		e.Use(ServiceAuthMiddleware(cfg.ServiceAuth, verifier))
+	}
+	if cfg.RateLimit.Enabled() {
+		e.Use(RateLimitMiddleware(cfg.RateLimit))
+	}
+
+	e.GET("/hc", h.Hc)
+	e.GET("/livez", health.Livez)
+	e.GET("/readyz", health.Readyz)
+	e.GET("/test", h.Test)
+	e.GET("/connect", h.Connect)
+
+	srv := &Server{Echo: e, Health: health}
+	if cfg.GRPCPort != 0 {
+		srv.GRPC, srv.GRPCHealth = newGRPCServer(cfg, upstream)
+	}
+	if cfg.MetricsPort != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		srv.Metrics = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	}
+	return srv
+}
+
+func (s *Server) MarkStarted() {
+	s.Health.MarkStarted()
+	s.setGRPCStatus(healthpb.HealthCheckResponse_SERVING)
+}
+
+func (s *Server) MarkDraining() {
+	s.Health.MarkDraining()
+	s.setGRPCStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+func (s *Server) setGRPCStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	if s.GRPCHealth == nil {
+		return
+	}
+	s.GRPCHealth.SetServingStatus("", status)
+	s.GRPCHealth.SetServingStatus(connectpb.ConnectService_ServiceDesc.ServiceName, status)
+}
+
+// Serve accepts HTTP/1.1 and cleartext HTTP/2 (h2c) on listener, so Service Connect
+// can proxy to the container with the http2 app protocol.
+func (s *Server) Serve(listener net.Listener) error {
+	h2s := &http2.Server{}
+	// sends GOAWAY to the h2c connections on Shutdown, they finish their streams
+	if err := http2.ConfigureServer(s.Echo.Server, h2s); err != nil {
+		return err
+	}
+
+	handler := h2c.NewHandler(s.Echo, h2s)
+	s.Echo.Server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handlers.Add(1)
+		defer s.handlers.Done()
+		handler.ServeHTTP(w, r)
+	})
+	s.Echo.Listener = listener
+	return s.Echo.Server.Serve(listener)
+}
+
+// Shutdown waits for in-flight requests on every server until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		if s.GRPC != nil {
+			s.GRPC.GracefulStop()
+		}
+		close(stopped)
+	}()
+
+	err := s.Echo.Shutdown(ctx)
+	// Echo's server does not wait for the hijacked h2c connections
+	handled := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-ctx.Done():
+		err = errors.Join(err, ctx.Err())
+	}
+	if s.Metrics != nil {
+		err = errors.Join(err, s.Metrics.Shutdown(ctx))
+	}
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		if s.GRPC != nil {
+			s.GRPC.Stop()
+		}
+	}
+	return err
+}
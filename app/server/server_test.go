@@ -0,0 +1,324 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sasaki-q/private/config"
+	"golang.org/x/net/http2"
+)
+
+func newTestConfig() config.Config {
+	cfg := config.Default()
+	cfg.ContainerName = "client"
+	return cfg
+}
+
+func newTestUpstream(t *testing.T, handler http.HandlerFunc) *HTTPUpstream {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return NewHTTPUpstream(strings.TrimPrefix(ts.URL, "http://"), time.Second)
+}
+
+func get(t *testing.T, srv *Server, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	srv.Echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeMessage(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unmarshal %q: %s", rec.Body.String(), err)
+	}
+	return body.Message
+}
+
+func TestHc(t *testing.T) {
+	rec := get(t, New(newTestConfig(), nil), "/hc")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := decodeMessage(t, rec); got != "client" {
+		t.Errorf("message = %q, want %q", got, "client")
+	}
+}
+
+func TestTest(t *testing.T) {
+	rec := get(t, New(newTestConfig(), nil), "/test")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := decodeMessage(t, rec); got != "test" {
+		t.Errorf("message = %q, want %q", got, "test")
+	}
+}
+
+func TestConnect(t *testing.T) {
+	var requestID string
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get("X-Request-Id")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"message":"server"}`))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/connect", nil)
+	req.Header.Set("X-Request-Id", "request-id")
+	rec := httptest.NewRecorder()
+	New(newTestConfig(), upstream).Echo.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if got, want := decodeMessage(t, rec), "FROM: client container → TO: server container"; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+	if requestID != "request-id" {
+		t.Errorf("upstream X-Request-Id = %q, want %q", requestID, "request-id")
+	}
+}
+
+func TestConnectUpstreamErrors(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		name     string
+		upstream *HTTPUpstream
+		want     string
+	}{
+		{
+			name:     "upstream down",
+			upstream: NewHTTPUpstream(strings.TrimPrefix(down.URL, "http://"), time.Second),
+			want:     "connection refused",
+		},
+		{
+			name: "upstream non-JSON",
+			upstream: newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("<html>bad gateway</html>"))
+			}),
+			want: "invalid character",
+		},
+		{
+			name: "upstream timeout",
+			upstream: func() *HTTPUpstream {
+				u := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+					select {
+					case <-r.Context().Done():
+					case <-time.After(time.Second):
+					}
+				})
+				u.Client.Timeout = 50 * time.Millisecond
+				return u
+			}(),
+			want: "Client.Timeout exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(t, New(newTestConfig(), tt.upstream), "/connect")
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectWithoutUpstream(t *testing.T) {
+	rec := get(t, New(newTestConfig(), nil), "/connect")
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+// the upstream is down, it must not take the task out of the target group
+func TestReadyz(t *testing.T) {
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	srv := New(newTestConfig(), upstream)
+
+	rec := get(t, srv, "/readyz")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("before start: status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	// the errors are logged, not served
+	if strings.Contains(rec.Body.String(), "startup has not completed") {
+		t.Errorf("before start: body = %s, want no error detail", rec.Body.String())
+	}
+
+	srv.Health.MarkStarted()
+	if rec := get(t, srv, "/readyz"); rec.Code != http.StatusOK {
+		t.Errorf("after start: status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	srv.Health.MarkDraining()
+	if rec := get(t, srv, "/readyz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("draining: status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	if rec := get(t, srv, "/livez"); rec.Code != http.StatusOK {
+		t.Errorf("livez while draining: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestMetricsPort(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.MetricsPort = 9090
+	srv := New(cfg, nil)
+
+	if rec := get(t, srv, "/metrics"); rec.Code != http.StatusNotFound {
+		t.Errorf("public port: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.Metrics.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "go_goroutines") {
+		t.Errorf("metrics port: status = %d, body = %.100s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMonitorUpstreams(t *testing.T) {
+	var down atomic.Bool
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	srv := New(newTestConfig(), upstream)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.Health.MonitorUpstreams(ctx, 10*time.Millisecond)
+
+	gauge := upstreamUp.WithLabelValues(upstream.Target())
+	waitFor := func(want float64) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for testutil.ToFloat64(gauge) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("upstream_up = %g, want %g", testutil.ToFloat64(gauge), want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	waitFor(1)
+	down.Store(true)
+	waitFor(0)
+	down.Store(false)
+	waitFor(1)
+}
+
+func TestServeH2C(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(newTestConfig(), nil)
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Echo.Close() })
+
+	url := "http://" + listener.Addr().String() + "/hc"
+	clients := map[string]*http.Client{
+		"HTTP/1.1": {},
+		"HTTP/2.0": {Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}},
+	}
+
+	for proto, client := range clients {
+		t.Run(proto, func(t *testing.T) {
+			resp, err := client.Get(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			if resp.Proto != proto {
+				t.Errorf("proto = %s, want %s", resp.Proto, proto)
+			}
+		})
+	}
+}
+
+// Shutdown must wait for the streams of h2c connections, http.Server does not
+// track them once the h2c handler hijacks them
+func TestShutdownH2C(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(newTestConfig(), nil)
+	started := make(chan struct{})
+	var finished atomic.Bool
+	srv.Echo.GET("/slow", func(c echo.Context) error {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		finished.Store(true)
+		return c.String(http.StatusOK, "done")
+	})
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Echo.Close() })
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	status := make(chan int, 1)
+	go func() {
+		resp, err := client.Get("http://" + listener.Addr().String() + "/slow")
+		if err != nil {
+			t.Error(err)
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %s", err)
+	}
+	if !finished.Load() {
+		t.Error("Shutdown returned before the in-flight request finished")
+	}
+	if got := <-status; got != http.StatusOK {
+		t.Errorf("status = %d, want %d", got, http.StatusOK)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sasaki-q/private/auth"
+	"github.com/sasaki-q/private/config"
+)
+
+// CallerContextKey holds the verified calling service on the echo context.
+const CallerContextKey string = "caller"
+
+// ServiceAuthMiddleware only lets the declared callers reach the protected paths.
+func ServiceAuthMiddleware(cfg config.ServiceAuth, verifier *auth.ServiceVerifier) echo.MiddlewareFunc {
+	protects := protectedPaths(cfg)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if !protects(req.URL.Path) {
+				return next(c)
+			}
+
+			caller, err := verifier.Verify(req)
+			if err != nil {
+				Logger(req.Context()).WarnContext(req.Context(), "rejected service call",
+					slog.String("caller", req.Header.Get(auth.HeaderServiceIdentity)),
+					slog.String("error", err.Error()),
+				)
+				return echo.NewHTTPError(http.StatusForbidden, "caller is not allowed")
+			}
+
+			c.Set(CallerContextKey, caller)
+			return next(c)
+		}
+	}
+}
+
+// protectedPaths also matches gRPC methods, whose path is /package.Service/Method.
+func protectedPaths(cfg config.ServiceAuth) func(path string) bool {
+	all := false
+	protected := map[string]bool{}
+	for _, v := range cfg.Paths {
+		all = all || v == "*"
+		protected[v] = true
+	}
+	exempt := map[string]bool{}
+	for _, v := range cfg.ExemptPaths {
+		exempt[v] = true
+	}
+
+	return func(path string) bool {
+		return !exempt[path] && (all || protected[path])
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sasaki-q/private/auth"
+)
+
+const (
+	testSecret  string = "0123456789abcdef0123456789abcdef"
+	otherSecret string = "fedcba9876543210fedcba9876543210"
+)
+
+func TestServiceAuth(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.ContainerName = "server"
+	cfg.ServiceAuth.AllowedCallers = []string{"client", "billing"}
+	cfg.ServiceAuth.CallerSecrets = map[string]string{"client": testSecret, "billing": otherSecret}
+	ts := httptest.NewServer(New(cfg, nil).Echo)
+	t.Cleanup(ts.Close)
+	address := strings.TrimPrefix(ts.URL, "http://")
+
+	tests := []struct {
+		name       string
+		signer     *auth.Signer
+		signed     string
+		path       string
+		wantStatus int
+	}{
+		{name: "declared caller", signer: auth.NewSigner("client", testSecret), signed: "/hc", path: "/hc", wantStatus: http.StatusOK},
+		{name: "declared caller with a query", signer: auth.NewSigner("client", testSecret), signed: "/hc?verbose=1", path: "/hc?verbose=1", wantStatus: http.StatusOK},
+		{name: "undeclared caller", signer: auth.NewSigner("other", testSecret), signed: "/hc", path: "/hc", wantStatus: http.StatusForbidden},
+		{name: "declared caller signing as another", signer: auth.NewSigner("client", otherSecret), signed: "/hc", path: "/hc", wantStatus: http.StatusForbidden},
+		{name: "other query", signer: auth.NewSigner("client", testSecret), signed: "/hc", path: "/hc?verbose=1", wantStatus: http.StatusForbidden},
+		{name: "unsigned", path: "/hc", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.signer != nil {
+				signed, err := http.NewRequest(http.MethodGet, ts.URL+tt.signed, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tt.signer.Sign(signed)
+				req.Header = signed.Header
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+
+	// the upstream signs the calls /connect makes
+	upstream := NewHTTPUpstream(address, time.Second)
+	upstream.Signer = auth.NewSigner("client", testSecret)
+	if rec := get(t, New(newTestConfig(), upstream), "/connect"); rec.Code != http.StatusOK {
+		t.Errorf("/connect status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	// health checks stay reachable without a signature
+	resp, err := http.Get(ts.URL + "/livez")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/livez status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	testTraceID     string = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testTraceparent string = "00-" + testTraceID + "-00f067aa0ba902b7-01"
+)
+
+// useTestTracing installs the propagators InitTracing does, without an exporter,
+// and a tracer provider recording the spans ended.
+func useTestTracing(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if _, err := InitTracing(context.Background(), "client"); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	provider, previous := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	recorder := useTestTracing(t)
+
+	var handled trace.SpanContext
+	e := echo.New()
+	e.Use(TracingMiddleware())
+	e.GET("/users/:id", func(c echo.Context) error {
+		handled = trace.SpanContextFromContext(c.Request().Context())
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name        string
+		traceparent string
+		wantRemote  bool
+	}{
+		{name: "traceparent", traceparent: testTraceparent, wantRemote: true},
+		{name: "no traceparent"},
+		{name: "invalid traceparent", traceparent: "00-" + testTraceID + "-0000000000000000-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(recorder.Ended())
+			req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			e.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := recorder.Ended()[before:]
+			if len(spans) != 1 {
+				t.Fatalf("spans = %d, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.Name() != "GET /users/:id" || span.SpanKind() != trace.SpanKindServer {
+				t.Errorf("span = %q (%s), want %q (server)", span.Name(), span.SpanKind(), "GET /users/:id")
+			}
+			if !handled.Equal(span.SpanContext()) {
+				t.Errorf("handler saw span %s, want %s", handled.SpanID(), span.SpanContext().SpanID())
+			}
+
+			if !tt.wantRemote {
+				if span.Parent().IsValid() {
+					t.Errorf("parent = %s, want a new trace", span.Parent().SpanID())
+				}
+				return
+			}
+			if got := span.SpanContext().TraceID().String(); got != testTraceID {
+				t.Errorf("trace id = %s, want the caller's %s", got, testTraceID)
+			}
+			if !span.Parent().IsRemote() || span.Parent().SpanID().String() != "00f067aa0ba902b7" {
+				t.Errorf("parent = %s (remote %t), want the caller's span 00f067aa0ba902b7", span.Parent().SpanID(), span.Parent().IsRemote())
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sasaki-q/private/auth"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Upstream is the service called by /connect.
+type Upstream interface {
+	// Target is the host:port used in logs and metric labels.
+	Target() string
+	GetMessage(ctx context.Context) (string, error)
+	// Ping is used by the readiness check.
+	Ping(ctx context.Context) error
+}
+
+type HTTPUpstream struct {
+	Address string
+	Client  *http.Client
+	// optional, signs requests for the upstream's ServiceAuthMiddleware
+	Signer *auth.Signer
+}
+
+func NewHTTPUpstream(address string, timeout time.Duration) *HTTPUpstream {
+	return &HTTPUpstream{Address: address, Client: &http.Client{Timeout: timeout}}
+}
+
+func (u *HTTPUpstream) Target() string {
+	return u.Address
+}
+
+func (u *HTTPUpstream) GetMessage(ctx context.Context) (string, error) {
+	target := u.Target()
+	upstreamRequestsTotal.WithLabelValues(target).Inc()
+	start := time.Now()
+	defer func() { upstreamRequestDuration.WithLabelValues(target).Observe(time.Since(start).Seconds()) }()
+
+	ctx, span := otel.Tracer(TracerName).Start(ctx, "GET /hc",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPRequestMethodGet, semconv.ServerAddress(target)),
+	)
+	defer span.End()
+
+	logger := Logger(ctx).With(slog.String("upstream", target))
+
+	uri, err := url.ParseRequestURI(fmt.Sprintf("http://%s/hc", target))
+	if err != nil {
+		logger.ErrorContext(ctx, "parse upstream uri", slog.String("error", err.Error()))
+		upstreamErrorsTotal.WithLabelValues(target, "parse").Inc()
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+
+	var tmp struct {
+		Message string `json:"message"`
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri.String(), nil)
+	if err != nil {
+		logger.ErrorContext(ctx, "build upstream request", slog.String("error", err.Error()))
+		upstreamErrorsTotal.WithLabelValues(target, "request").Inc()
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+	// traceparent lets the upstream continue this trace across the Service Connect hop
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if id := RequestIDFrom(ctx); id != "" {
+		req.Header.Set(echo.HeaderXRequestID, id)
+	}
+	if u.Signer != nil {
+		u.Signer.Sign(req)
+	}
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		logger.ErrorContext(ctx, "upstream request", slog.String("error", err.Error()))
+		upstreamErrorsTotal.WithLabelValues(target, "request").Inc()
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("upstream returned status %d", resp.StatusCode)
+		logger.ErrorContext(ctx, "upstream response", slog.String("error", err.Error()), slog.Int("status", resp.StatusCode))
+		upstreamErrorsTotal.WithLabelValues(target, "status").Inc()
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.ErrorContext(ctx, "read upstream response", slog.String("error", err.Error()))
+		upstreamErrorsTotal.WithLabelValues(target, "request").Inc()
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+
+	if err := json.Unmarshal(body, &tmp); err != nil {
+		logger.ErrorContext(ctx, "unmarshal upstream response", slog.String("error", err.Error()), slog.Int("status", resp.StatusCode))
+		upstreamErrorsTotal.WithLabelValues(target, "unmarshal").Inc()
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+
+	return tmp.Message, nil
+}
+
+func (u *HTTPUpstream) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/livez", u.Address), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
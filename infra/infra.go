@@ -3,10 +3,21 @@ package main
 import (
 	"fmt"
 	resource "infra/resources"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awscodepipeline"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsecr"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsecs"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssecretsmanager"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsservicediscovery"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
 )
@@ -20,34 +31,159 @@ type Props struct {
 	GithubOwner      string
 	GithubRepository string
 	Project          string
+	// comma separated origins allowed to call the public client service, empty disables CORS
+	CorsAllowOrigins string
+	// encrypt Service Connect traffic, creates a private CA billed monthly
+	ServiceConnectTls bool
+	// e.g. t3.nano, replaces the NAT gateways with cheaper NAT instances for dev. Their
+	// AMI is looked up in the account and region of the CLI's credentials.
+	NatInstanceType string
+	// look up an existing VPC by id or tags instead of creating one, it needs public
+	// subnets for the ALB and private ones with egress for the tasks
+	VpcId   string
+	VpcTags map[string]string
+	// FlowLogsToLogs or FlowLogsToS3 records the VPC's traffic, empty disables flow logs
+	FlowLogs        string
+	FlowLogsTraffic awsec2.FlowLogTrafficType
+	// the ALB serves HTTPS on <Subdomain>.<HostedZoneName> and redirects HTTP to it,
+	// the zone's id and name are required
+	HostedZoneId   string
+	HostedZoneName string
+	Subdomain      string
+}
+
+func (e Props) lookupVpc() bool {
+	return e.VpcId != "" || len(e.VpcTags) > 0
+}
+
+// lookups reports whether synthesizing the app queries the account, the existing
+// VPC or the NAT instances' AMI, which needs the stacks' account and region.
+func (e Props) lookups() bool {
+	return e.lookupVpc() || e.NatInstanceType != ""
+}
+
+func (e Props) domainName() string {
+	if e.Subdomain == "" {
+		return e.HostedZoneName
+	}
+	return fmt.Sprintf("%s.%s", e.Subdomain, e.HostedZoneName)
 }
 
 const (
 	VpcName string = "service-connect"
 	VpcCidr string = "192.168.0.0/16"
+	// the private subnets of every zone share VpcNatGateways NAT gateways
+	VpcMaxAzs      float64 = 2
+	VpcNatGateways float64 = 1
 
 	KeyName       string = "service-connect-log-group-key"
 	LogBucketName string = "service-connect-log-bucket-2024-10-01"
 	LogGroupName  string = "service-connect-log-group"
 
+	FlowLogName    string = "service-connect-flow-log"
+	FlowLogsToLogs string = "logs"
+	FlowLogsToS3   string = "s3"
+	FlowLogsPrefix string = "flow-logs/"
+
 	ClusterName string = "cluster"
 	Namespace   string = "local"
+	// the client and server's domain, services of other domains or teams join their
+	// own namespace and cannot resolve these aliases
+	ConnectNamespace string = "connect"
 
 	RepositoryName string = "repository"
 
 	ALBName         string = "alb"
 	TargetGroupName string = "target-group"
 	ListenerName    string = "listener"
+	CertificateName string = "certificate"
+	RecordName      string = "alb-record"
 
 	BlueTargetGroupName  string = "blue-target-group"
 	BlueListener         string = "blue-listener"
 	GreenTargetGroupName string = "green-target-group"
 	GreenListener        string = "green-listener"
 
+	// seconds, the app drains for DrainPeriod and then waits up to ShutdownTimeout for in-flight requests
+	DrainPeriod     float64 = 15
+	ShutdownTimeout float64 = 10
+	StopTimeout     float64 = DrainPeriod + ShutdownTimeout + 5
+
+	// seconds, the ALB takes a draining task out of the target group within
+	// DrainPeriod, see validateHealthCheck, and a started one in after as long
+	HealthCheckInterval  float64 = 5
+	HealthCheckTimeout   float64 = 4
+	HealthCheckThreshold float64 = 2
+
+	// seconds, the Service Connect proxy must not give up before the ALB in front of it
+	// or the app calling it, which waits UpstreamTimeout for an answer
+	AlbIdleTimeout    float64 = 60
+	UpstreamTimeout   float64 = 5
+	PerRequestTimeout float64 = 15
+	IdleTimeout       float64 = 120
+
+	MetricsNamespace string = "ServiceConnect"
+	// every app serves /metrics on localhost only, for the collector in its task
+	MetricsPort float64 = 9090
+
+	ServiceAuthSecretName string = "service-connect-service-auth"
+
+	PrivateCaName            string = "service-connect-ca"
+	ServiceConnectTlsKeyName string = "service-connect-tls-key"
+	ServiceConnectTlsRole    string = "service-connect-tls-role"
+
 	Branch         string = "main"
 	PipelineBucket string = "codepipeline-artifact-bucket-2024-02-17"
 )
 
+// SSM parameters NewPlatformStack publishes for the service stacks, the resources
+// with fixed names are looked up by name instead
+const (
+	VpcIdParameter                string = "vpc-id"
+	PublicSubnetIdsParameter      string = "public-subnet-ids"
+	PrivateSubnetIdsParameter     string = "private-subnet-ids"
+	NamespaceArnParameter         string = "namespace-arn"
+	NamespaceIdParameter          string = "namespace-id"
+	ServiceAuthSecretArnParameter string = "service-auth-secret-arn"
+	PrivateCaArnParameter         string = "private-ca-arn"
+	TlsKeyArnParameter            string = "service-connect-tls-key-arn"
+)
+
+func PlatformParameter(project string, name string) string {
+	return fmt.Sprintf("/%s/platform/%s", project, name)
+}
+
+// SSM parameters a service stack publishes for its callers' stacks
+const (
+	SecurityGroupIdParameter string = "security-group-id"
+)
+
+func ServiceParameter(project string, service string, name string) string {
+	return fmt.Sprintf("/%s/%s/%s", project, service, name)
+}
+
+// NamespaceParameter names the parameter of one of the AdditionalNamespaces, e.g.
+// connect-namespace-arn.
+func NamespaceParameter(namespace string, name string) string {
+	return fmt.Sprintf("%s-%s", namespace, name)
+}
+
+// the cluster's namespaces besides the default one
+var AdditionalNamespaces = []string{ConnectNamespace}
+
+// CallerParameter names the parameter of one of the ServiceAuthCallers, e.g.
+// client_container-service-auth-secret-arn.
+func CallerParameter(caller string, name string) string {
+	return fmt.Sprintf("%s-%s", caller, name)
+}
+
+// the containers signing their calls to other services, each with its own secret
+var ServiceAuthCallers = []string{ClientContainerName}
+
+func serviceAuthSecretName(caller string) string {
+	return fmt.Sprintf("%s-%s", ServiceAuthSecretName, caller)
+}
+
 const (
 	ClientRepositoryName string  = "client_repository"
 	ClientTaskName       string  = "client_task_definition"
@@ -62,19 +198,127 @@ const (
 	ServerContainerName  string  = "server_container"
 	ServerPort           float64 = 8001
 	ServerServiceName    string  = "server_service"
+	ServerGrpcPort       float64 = 9001
+	ServerGrpcName       string  = "server_service_grpc"
+	ServerAlias          string  = "server." + ConnectNamespace
+	ServerGrpcAlias      string  = "server-grpc." + ConnectNamespace
 )
 
+const (
+	ClientSecurityGroupName string = "client-service"
+	ServerSecurityGroupName string = "server-service"
+)
+
+// the services reach the AWS APIs through the NAT or the VPC endpoints, other egress
+// follows the dependencies
+var ServiceEgressPorts = []float64{443}
+
+// NewInfraStack deploys the platform and every service in a single stack.
 func NewInfraStack(scope constructs.Construct, id string, props *InfraStackProps, e Props) awscdk.Stack {
+	stack := newStack(scope, id, props)
+	var i resource.IResourceService = &resource.ResourceService{S: stack}
+
+	p := newPlatform(i, e)
+	serverSecurityGroup := addServer(i, p)
+	addClient(i, e, p, map[string]awsec2.Connections{ServerServiceName: serverSecurityGroup.Connections()})
+
+	return stack
+}
+
+// NewPlatformStack deploys the infrastructure the services share and publishes what
+// the service stacks import under PlatformParameter. The service stacks read the
+// parameters in their own account and region, which must be the platform's.
+func NewPlatformStack(scope constructs.Construct, id string, props *InfraStackProps, e Props) awscdk.Stack {
+	stack := newStack(scope, id, props)
+	var i resource.IResourceService = &resource.ResourceService{S: stack}
+
+	p := newPlatform(i, e)
+
+	subnetIds := func(subnets []awsec2.ISubnet) *string {
+		ids := []*string{}
+		for _, v := range subnets {
+			ids = append(ids, v.SubnetId())
+		}
+		return awscdk.Fn_Join(jsii.String(","), &ids)
+	}
+
+	i.NewParameter(PlatformParameter(e.Project, VpcIdParameter), p.Vpc.VpcId())
+	i.NewParameter(PlatformParameter(e.Project, PublicSubnetIdsParameter), subnetIds(*p.Vpc.PublicSubnets()))
+	i.NewParameter(PlatformParameter(e.Project, PrivateSubnetIdsParameter), subnetIds(*p.Vpc.PrivateSubnets()))
+	i.NewParameter(PlatformParameter(e.Project, NamespaceArnParameter), p.Cluster.DefaultCloudMapNamespace().NamespaceArn())
+	i.NewParameter(PlatformParameter(e.Project, NamespaceIdParameter), p.Cluster.DefaultCloudMapNamespace().NamespaceId())
+	for _, v := range AdditionalNamespaces {
+		i.NewParameter(PlatformParameter(e.Project, NamespaceParameter(v, NamespaceArnParameter)), p.Namespaces[v].NamespaceArn())
+		i.NewParameter(PlatformParameter(e.Project, NamespaceParameter(v, NamespaceIdParameter)), p.Namespaces[v].NamespaceId())
+	}
+	for _, v := range ServiceAuthCallers {
+		i.NewParameter(PlatformParameter(e.Project, CallerParameter(v, ServiceAuthSecretArnParameter)), p.ServiceAuthSecrets[v].SecretArn())
+	}
+	if p.Tls != nil {
+		i.NewParameter(PlatformParameter(e.Project, PrivateCaArnParameter), p.Tls.CertificateAuthorityArn)
+		i.NewParameter(PlatformParameter(e.Project, TlsKeyArnParameter), p.Tls.KmsKey.KeyArn())
+	}
+
+	return stack
+}
+
+// NewServerStack deploys the server service on the platform NewPlatformStack published
+// and publishes its security group id under ServiceParameter for its callers.
+func NewServerStack(scope constructs.Construct, id string, props *InfraStackProps, e Props) awscdk.Stack {
+	stack := newStack(scope, id, props)
+	var i resource.IResourceService = &resource.ResourceService{S: stack}
+
+	serverSecurityGroup := addServer(i, importPlatform(i, stack, e))
+	i.NewParameter(ServiceParameter(e.Project, ServerServiceName, SecurityGroupIdParameter), serverSecurityGroup.SecurityGroupId())
+
+	return stack
+}
+
+// NewClientStack deploys the client service, its load balancer and pipeline on the
+// platform NewPlatformStack published. It imports the server's security group from
+// the parameter NewServerStack published and owns the rules letting the client in,
+// so the server deploys without knowing its callers and neither stack exports to the
+// other. The parameter is read in the client stack's account and region, which must
+// be the server's.
+func NewClientStack(scope constructs.Construct, id string, props *InfraStackProps, e Props) awscdk.Stack {
+	stack := newStack(scope, id, props)
+	var i resource.IResourceService = &resource.ResourceService{S: stack}
+
+	serverSecurityGroup := i.GetSecurityGroupFromId(ServerSecurityGroupName, i.GetParameterFromName(ServiceParameter(e.Project, ServerServiceName, SecurityGroupIdParameter)))
+
+	addClient(i, e, importPlatform(i, stack, e), map[string]awsec2.Connections{ServerServiceName: serverSecurityGroup.Connections()})
+
+	return stack
+}
+
+func newStack(scope constructs.Construct, id string, props *InfraStackProps) awscdk.Stack {
 	var sprops awscdk.StackProps
 	if props != nil {
 		sprops = props.StackProps
 	}
-	stack := awscdk.NewStack(scope, &id, &sprops)
+	return awscdk.NewStack(scope, &id, &sprops)
+}
 
-	var i resource.IResourceService = &resource.ResourceService{S: stack}
+// platform is the infrastructure the services share, created by newPlatform or
+// imported by importPlatform.
+type platform struct {
+	Vpc     awsec2.IVpc
+	Cluster awsecs.ICluster
+	// AdditionalNamespaces by name
+	Namespaces     map[string]awsservicediscovery.INamespace
+	LogGroup       awslogs.ILogGroup
+	Repository     awsecr.IRepository
+	PipelineBucket awss3.IBucket
+	// ServiceAuthCallers by name, each signs its calls with its own secret and
+	// the services it calls verify them with it
+	ServiceAuthSecrets map[string]awssecretsmanager.ISecret
+	// nil unless Props.ServiceConnectTls
+	Tls *resource.ServiceConnectTls
+}
 
+func newPlatform(i resource.IResourceService, e Props) platform {
 	// VPC
-	vpc := i.NewVpc(VpcName, VpcCidr)
+	vpc := newVpc(i, e)
 
 	// KMS
 	key := i.NewKey(KeyName, "logs.amazonaws.com")
@@ -86,95 +330,368 @@ func NewInfraStack(scope constructs.Construct, id string, props *InfraStackProps
 	logBucket := i.NewBucket(LogBucketName)
 	pipelineBucket := i.NewBucket(PipelineBucket)
 
+	// Flow Logs
+	validateFlowLogs(e.FlowLogs, e.FlowLogsTraffic)
+	switch e.FlowLogs {
+	case FlowLogsToLogs:
+		i.NewFlowLog(resource.NewFlowLogProps{Name: FlowLogName, Vpc: vpc, TrafficType: e.FlowLogsTraffic, LogGroup: logGroup})
+	case FlowLogsToS3:
+		i.NewFlowLog(resource.NewFlowLogProps{Name: FlowLogName, Vpc: vpc, TrafficType: e.FlowLogsTraffic, Bucket: logBucket, Prefix: FlowLogsPrefix})
+	}
+
 	// ECR
 	repository := i.NewEcrRepository(RepositoryName)
 
 	// ECS
-	cluster := i.NewCluster(resource.NewClusterProps{
-		ClusterName: ClusterName,
-		NameSpace:   Namespace,
-		LogBucket:   logBucket,
-		LogGroup:    logGroup,
-		Vpc:         vpc,
+	clusterValue := i.NewCluster(resource.NewClusterProps{
+		ClusterName:          ClusterName,
+		NameSpace:            Namespace,
+		AdditionalNamespaces: AdditionalNamespaces,
+		LogBucket:            logBucket,
+		LogGroup:             logGroup,
+		Vpc:                  vpc,
 	})
 
-	// Client Container
-	clientTaskDefinitiopn := i.NewTaskDefinition(ClientTaskName)
+	var tls *resource.ServiceConnectTls
+	if e.ServiceConnectTls {
+		tlsKey := i.NewKey(ServiceConnectTlsKeyName, "ecs.amazonaws.com")
+		ca := i.NewPrivateCa(PrivateCaName, Namespace)
+		tls = &resource.ServiceConnectTls{
+			CertificateAuthorityArn: ca.CertificateAuthority.AttrArn(),
+			Activation:              ca.Activation,
+			KmsKey:                  tlsKey,
+			Role:                    i.NewServiceConnectTlsRole(ServiceConnectTlsRole, tlsKey),
+		}
+	}
 
-	i.AddContainer(resource.AddContainerProps{
-		ContainerName:   ClientContainerName,
-		Port:            ClientPort,
-		PortMappingName: ClientServiceName,
-		Env: map[string]*string{
-			"PORT":           jsii.String(fmt.Sprintf("%g", ClientPort)),
-			"CONTAINER_NAME": jsii.String(ClientContainerName),
-			"CONTAINER_HOST": jsii.String(fmt.Sprintf("%s.%s", ServerServiceName, Namespace)),
-			"CONTAINER_PORT": jsii.String(fmt.Sprintf("%g", ServerPort)),
+	serviceAuthSecrets := map[string]awssecretsmanager.ISecret{}
+	for _, v := range ServiceAuthCallers {
+		serviceAuthSecrets[v] = i.NewSecret(serviceAuthSecretName(v))
+	}
+
+	return platform{
+		Vpc:                vpc,
+		Cluster:            clusterValue.Cluster,
+		Namespaces:         clusterValue.Namespaces,
+		LogGroup:           logGroup,
+		Repository:         repository,
+		PipelineBucket:     pipelineBucket,
+		ServiceAuthSecrets: serviceAuthSecrets,
+		Tls:                tls,
+	}
+}
+
+// newVpc looks up the shared VPC Props names, or creates one.
+func newVpc(i resource.IResourceService, e Props) awsec2.IVpc {
+	if e.lookupVpc() {
+		// the VPC's owner manages its NAT and endpoints
+		return i.LookupVpc(VpcName, resource.LookupVpcProps{VpcId: e.VpcId, Tags: e.VpcTags})
+	}
+
+	return i.NewVpc(resource.NewVpcProps{
+		VpcName:         VpcName,
+		Cidr:            VpcCidr,
+		MaxAzs:          VpcMaxAzs,
+		NatGateways:     jsii.Number(VpcNatGateways),
+		NatInstanceType: e.NatInstanceType,
+		IsolatedSubnets: true,
+		// the tasks pull their image and secrets and ship their logs without the NAT
+		GatewayEndpoints: map[string]awsec2.GatewayVpcEndpointAwsService{
+			"s3": awsec2.GatewayVpcEndpointAwsService_S3(),
+		},
+		InterfaceEndpoints: []awsec2.InterfaceVpcEndpointAwsService{
+			awsec2.InterfaceVpcEndpointAwsService_ECR(),
+			awsec2.InterfaceVpcEndpointAwsService_ECR_DOCKER(),
+			awsec2.InterfaceVpcEndpointAwsService_CLOUDWATCH_LOGS(),
+			awsec2.InterfaceVpcEndpointAwsService_SECRETS_MANAGER(),
+			awsec2.InterfaceVpcEndpointAwsService_SSM(),
 		},
-		Image:    awsecs.ContainerImage_FromEcrRepository(repository, jsii.String("882367fb2ca2760abc041a3d58d9d60dc45818db")),
-		LogGroup: logGroup,
-		Task:     clientTaskDefinitiopn,
 	})
+}
 
-	clientService := i.NewService(resource.NewServiceProps{
-		ServiceName:    ClientServiceName,
-		Port:           ClientPort,
-		DesiredCount:   1,
-		MaxCount:       jsii.Number(5),
-		Cluster:        cluster,
-		LogGroup:       logGroup,
-		Subnets:        *vpc.PrivateSubnets(),
-		TaskDefinition: clientTaskDefinitiopn,
+// importPlatform looks up the resources with fixed names and reads the others from
+// the parameters NewPlatformStack published.
+func importPlatform(i resource.IResourceService, stack awscdk.Stack, e Props) platform {
+	parameter := func(name string) *string {
+		return i.GetParameterFromName(PlatformParameter(e.Project, name))
+	}
+
+	var vpc awsec2.IVpc
+	if e.lookupVpc() {
+		vpc = newVpc(i, e)
+	} else {
+		// NewVpc spreads the VPC over VpcMaxAzs zones, one subnet of each tier per zone
+		azs := *stack.AvailabilityZones()
+		if len(azs) > int(VpcMaxAzs) {
+			azs = azs[:int(VpcMaxAzs)]
+		}
+		n := jsii.Number(len(azs))
+
+		vpc = i.GetVpcFromAttributes(VpcName, resource.GetVpcFromAttributesProps{
+			VpcId:             parameter(VpcIdParameter),
+			AvailabilityZones: azs,
+			PublicSubnetIds:   *awscdk.Fn_Split(jsii.String(","), parameter(PublicSubnetIdsParameter), n),
+			PrivateSubnetIds:  *awscdk.Fn_Split(jsii.String(","), parameter(PrivateSubnetIdsParameter), n),
+		})
+	}
+
+	namespaces := []resource.NamespaceAttributes{}
+	for _, v := range AdditionalNamespaces {
+		namespaces = append(namespaces, resource.NamespaceAttributes{
+			Name: v,
+			Arn:  parameter(NamespaceParameter(v, NamespaceArnParameter)),
+			Id:   parameter(NamespaceParameter(v, NamespaceIdParameter)),
+		})
+	}
+
+	clusterValue := i.GetClusterFromName(resource.GetClusterFromNameProps{
+		ClusterName:          ClusterName,
+		NameSpace:            resource.NamespaceAttributes{Name: Namespace, Arn: parameter(NamespaceArnParameter), Id: parameter(NamespaceIdParameter)},
+		AdditionalNamespaces: namespaces,
+		Vpc:                  vpc,
 	})
 
+	var tls *resource.ServiceConnectTls
+	if e.ServiceConnectTls {
+		tls = &resource.ServiceConnectTls{
+			CertificateAuthorityArn: parameter(PrivateCaArnParameter),
+			KmsKey:                  i.GetKeyFromArn(ServiceConnectTlsKeyName, parameter(TlsKeyArnParameter)),
+			Role:                    i.GetRoleFromName(ServiceConnectTlsRole),
+		}
+	}
+
+	serviceAuthSecrets := map[string]awssecretsmanager.ISecret{}
+	for _, v := range ServiceAuthCallers {
+		serviceAuthSecrets[v] = i.GetSecretFromArn(serviceAuthSecretName(v), parameter(CallerParameter(v, ServiceAuthSecretArnParameter)))
+	}
+
+	return platform{
+		Vpc:                vpc,
+		Cluster:            clusterValue.Cluster,
+		Namespaces:         clusterValue.Namespaces,
+		LogGroup:           i.GetLogGroupFromName(LogGroupName),
+		Repository:         i.GetEcrRepositoryFromName(RepositoryName),
+		PipelineBucket:     i.GetBucketFromName(PipelineBucket),
+		ServiceAuthSecrets: serviceAuthSecrets,
+		Tls:                tls,
+	}
+}
+
+// the client calls the server through its client aliases, the service names contain
+// underscores and do not belong in URLs
+var serverConnectServices = []resource.ServiceConnectService{
+	{PortMappingName: ServerServiceName, DnsName: ServerAlias, Port: ServerPort, PerRequestTimeout: PerRequestTimeout, IdleTimeout: IdleTimeout},
+	{PortMappingName: ServerGrpcName, DnsName: ServerGrpcAlias, Port: ServerGrpcPort, PerRequestTimeout: PerRequestTimeout, IdleTimeout: IdleTimeout},
+}
+
+// /connect calls the server's ConnectService through its gRPC alias
+var clientUpstream = dependency{Namespace: ConnectNamespace, Host: ServerGrpcAlias, Port: ServerGrpcPort}
+
+// every endpoint the client calls, the server calls none
+var clientDependencies = []dependency{clientUpstream}
+
+// the services callers resolve their dependencies against
+var publishers = []publisher{
+	{Service: ServerServiceName, Namespace: ConnectNamespace, Services: serverConnectServices, PortMappings: serverPortMappings},
+}
+
+var serverPortMappings = []resource.PortMapping{
+	{Name: ServerServiceName, Port: ServerPort, AppProtocol: awsecs.AppProtocol_Http2()},
+	{Name: ServerGrpcName, Port: ServerGrpcPort, AppProtocol: awsecs.AppProtocol_Grpc()},
+}
+
+// signingSecrets injects caller's own secret only, it cannot sign as another caller.
+func signingSecrets(p platform, caller string) map[string]awsecs.Secret {
+	return map[string]awsecs.Secret{
+		"SERVICE_AUTH_SECRET": awsecs.Secret_FromSecretsManager(p.ServiceAuthSecrets[caller], nil),
+	}
+}
+
+// verifyingSecrets injects the secret of each of callers, which must also be
+// passed as SERVICE_AUTH_ALLOWED_CALLERS.
+func verifyingSecrets(p platform, callers ...string) map[string]awsecs.Secret {
+	secrets := map[string]awsecs.Secret{}
+	for _, v := range callers {
+		secrets[callerSecretEnv(v)] = awsecs.Secret_FromSecretsManager(p.ServiceAuthSecrets[v], nil)
+	}
+	return secrets
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Za-z0-9]`)
+
+// callerSecretEnv names the variable the app reads caller's secret from, see
+// config.CallerSecretEnv in the app.
+func callerSecretEnv(caller string) string {
+	return "SERVICE_AUTH_CALLER_SECRET_" + strings.ToUpper(nonAlphanumeric.ReplaceAllString(caller, "_"))
+}
+
+// addServer deploys the server and returns its security group, its callers add their
+// own ingress rules to it, see addClient.
+func addServer(i resource.IResourceService, p platform) awsec2.ISecurityGroup {
+	// the server is not behind the ALB
+	validateTimeouts(serverConnectServices, 0, UpstreamTimeout)
+
 	// Server Container
 	serverTaskDefinitiopn := i.NewTaskDefinition(ServerTaskName)
 
 	i.AddContainer(resource.AddContainerProps{
-		ContainerName:   ServerContainerName,
-		Port:            ServerPort,
-		PortMappingName: ServerServiceName,
+		ContainerName: ServerContainerName,
+		PortMappings:  serverPortMappings,
+		StopTimeout:   StopTimeout,
 		Env: map[string]*string{
 			"PORT":           jsii.String(fmt.Sprintf("%g", ServerPort)),
 			"CONTAINER_NAME": jsii.String(ServerContainerName),
-			"CONTAINER_HOST": jsii.String(fmt.Sprintf("%s.%s", ClientServiceName, Namespace)),
-			"CONTAINER_PORT": jsii.String(fmt.Sprintf("%g", ClientPort)),
+			"GRPC_PORT":      jsii.String(fmt.Sprintf("%g", ServerGrpcPort)),
+			"METRICS_PORT":   jsii.String(fmt.Sprintf("%g", MetricsPort)),
+			// no CONTAINER_HOST, the server has no dependency: the client it used to
+			// call runs client only and publishes no alias. Its /readyz checks startup
+			// and draining, nothing routes on it as the server is not behind the ALB.
+			"DRAIN_PERIOD":                 jsii.String(fmt.Sprintf("%gs", DrainPeriod)),
+			"SHUTDOWN_TIMEOUT":             jsii.String(fmt.Sprintf("%gs", ShutdownTimeout)),
+			"SERVICE_AUTH_ALLOWED_CALLERS": jsii.String(ClientContainerName),
 		},
-		Image:    awsecs.ContainerImage_FromEcrRepository(repository, jsii.String("882367fb2ca2760abc041a3d58d9d60dc45818db")),
-		LogGroup: logGroup,
-		Task:     serverTaskDefinitiopn,
+		Secrets:   verifyingSecrets(p, ClientContainerName),
+		Image:     awsecs.ContainerImage_FromEcrRepository(p.Repository, jsii.String("882367fb2ca2760abc041a3d58d9d60dc45818db")),
+		LogGroup:  p.LogGroup,
+		Task:      serverTaskDefinitiopn,
+		Collector: &resource.CollectorProps{Metrics: true, MetricsNamespace: MetricsNamespace, MetricsPort: MetricsPort, Traces: true},
 	})
 
-	serverService := i.NewService(resource.NewServiceProps{
-		ServiceName:    ServerServiceName,
-		Port:           ServerPort,
-		Cluster:        cluster,
-		LogGroup:       logGroup,
-		Subnets:        *vpc.PrivateSubnets(),
-		TaskDefinition: serverTaskDefinitiopn,
+	serverSecurityGroup := i.NewSecurityGroup(resource.NewSecurityGroupProps{
+		Name:        ServerSecurityGroupName,
+		Description: "server service, reached by its callers through Service Connect",
+		Vpc:         p.Vpc,
+		EgressPorts: ServiceEgressPorts,
 	})
 
-	// Service Connect
-	i.NewServiceConnection(resource.NewServiceConnectionProps{
-		ToConnection:   serverService.Connections(),
-		ToPort:         ServerPort,
-		FromConnection: clientService.Connections(),
+	i.NewService(resource.NewServiceProps{
+		ServiceName:            ServerServiceName,
+		Namespace:              *p.Namespaces[ConnectNamespace].NamespaceArn(),
+		ServiceConnectServices: serverConnectServices,
+		Tls:                    p.Tls,
+		SecurityGroups:         []awsec2.ISecurityGroup{serverSecurityGroup},
+		Cluster:                p.Cluster,
+		LogGroup:               p.LogGroup,
+		Subnets:                *p.Vpc.PrivateSubnets(),
+		TaskDefinition:         serverTaskDefinitiopn,
 	})
 
+	return serverSecurityGroup
+}
+
+// addClient deploys the client behind the ALB. publisherConnections holds the
+// connections of every service the client's dependencies resolve to, by name.
+func addClient(i resource.IResourceService, e Props, p platform, publisherConnections map[string]awsec2.Connections) {
+	upstreams := resolveDependencies(ClientServiceName, publishers, clientDependencies)
+
+	// Client Container
+	clientTaskDefinitiopn := i.NewTaskDefinition(ClientTaskName)
+
+	clientEnv := map[string]*string{
+		"PORT":           jsii.String(fmt.Sprintf("%g", ClientPort)),
+		"CONTAINER_NAME": jsii.String(ClientContainerName),
+		"METRICS_PORT":   jsii.String(fmt.Sprintf("%g", MetricsPort)),
+		// /connect calls the server's ConnectService over gRPC
+		"CONTAINER_HOST":    jsii.String(clientUpstream.Host),
+		"CONTAINER_PORT":    jsii.String(fmt.Sprintf("%g", clientUpstream.Port)),
+		"UPSTREAM_PROTOCOL": jsii.String("grpc"),
+		"UPSTREAM_TIMEOUT":  jsii.String(fmt.Sprintf("%gs", UpstreamTimeout)),
+		"DRAIN_PERIOD":      jsii.String(fmt.Sprintf("%gs", DrainPeriod)),
+		"SHUTDOWN_TIMEOUT":  jsii.String(fmt.Sprintf("%gs", ShutdownTimeout)),
+		// per task, the ALB spreads clients over every task. The IP limit applies
+		// before authentication, higher as users may share an IP behind a NAT.
+		"RATE_LIMIT_IP_RPS":       jsii.String("20"),
+		"RATE_LIMIT_IP_BURST":     jsii.String("40"),
+		"RATE_LIMIT_CLIENT_RPS":   jsii.String("10"),
+		"RATE_LIMIT_CLIENT_BURST": jsii.String("20"),
+	}
+	if e.CorsAllowOrigins != "" {
+		clientEnv["CORS_ALLOW_ORIGINS"] = jsii.String(e.CorsAllowOrigins)
+	}
+
+	i.AddContainer(resource.AddContainerProps{
+		ContainerName: ClientContainerName,
+		PortMappings:  []resource.PortMapping{{Name: ClientServiceName, Port: ClientPort}},
+		StopTimeout:   StopTimeout,
+		Env:           clientEnv,
+		Secrets:       signingSecrets(p, ClientContainerName),
+		Image:         awsecs.ContainerImage_FromEcrRepository(p.Repository, jsii.String("882367fb2ca2760abc041a3d58d9d60dc45818db")),
+		LogGroup:      p.LogGroup,
+		Task:          clientTaskDefinitiopn,
+		Collector:     &resource.CollectorProps{Metrics: true, MetricsNamespace: MetricsNamespace, MetricsPort: MetricsPort, Traces: true},
+	})
+
+	// client only, it resolves the server's aliases but is only reached through the ALB
+	clientSecurityGroup := i.NewSecurityGroup(resource.NewSecurityGroupProps{
+		Name:        ClientSecurityGroupName,
+		Description: "client service, reached through the ALB",
+		Vpc:         p.Vpc,
+		EgressPorts: ServiceEgressPorts,
+	})
+
+	clientService := i.NewService(resource.NewServiceProps{
+		ServiceName:    ClientServiceName,
+		Namespace:      *p.Namespaces[clientUpstream.Namespace].NamespaceArn(),
+		SecurityGroups: []awsec2.ISecurityGroup{clientSecurityGroup},
+		DesiredCount:   1,
+		MaxCount:       jsii.Number(5),
+		Cluster:        p.Cluster,
+		LogGroup:       p.LogGroup,
+		Subnets:        *p.Vpc.PrivateSubnets(),
+		TaskDefinition: clientTaskDefinitiopn,
+	})
+
+	// Service Connect, one rule per declared dependency, the client reaches the proxy of
+	// the publisher's tasks on the container port
+	for _, v := range upstreams {
+		to, ok := publisherConnections[v.Publisher]
+		if !ok {
+			panic(fmt.Sprintf("%s: %s publishes %s:%g but is not deployed with it", ClientServiceName, v.Publisher, v.Host, v.Port))
+		}
+		i.NewServiceConnection(resource.NewServiceConnectionProps{
+			ToConnection:   to,
+			ToPort:         v.ContainerPort,
+			FromConnection: clientService.Connections(),
+			Description:    fmt.Sprintf("%s to %s:%g", ClientServiceName, v.Host, v.Port),
+		})
+	}
+
 	// Load Balancer
-	alb := i.NewAlb(ALBName, vpc)
+	alb := i.NewAlb(ALBName, p.Vpc, AlbIdleTimeout)
+	// the target group would add the same rule without a description
+	i.NewServiceConnection(resource.NewServiceConnectionProps{
+		ToConnection:   clientService.Connections(),
+		ToPort:         ClientPort,
+		FromConnection: alb.Connections(),
+		Description:    fmt.Sprintf("%s to %s", ALBName, ClientServiceName),
+	})
+	validateHealthCheck(HealthCheckInterval, HealthCheckTimeout, HealthCheckThreshold, DrainPeriod)
 	targetGroup := i.NewTargetGroup(resource.NewTargetGroupProps{
-		Name:    TargetGroupName,
-		Port:    ClientPort,
-		Service: clientService,
-		Vpc:     vpc,
+		Name:                 TargetGroupName,
+		Port:                 ClientPort,
+		DeregistrationDelay:  DrainPeriod,
+		HealthCheckInterval:  HealthCheckInterval,
+		HealthCheckTimeout:   HealthCheckTimeout,
+		HealthCheckThreshold: HealthCheckThreshold,
+		Service:              clientService,
+		Vpc:                  p.Vpc,
 	})
-	i.AddListener(resource.AddListenerProps{
+	if e.HostedZoneId == "" || e.HostedZoneName == "" {
+		panic(fmt.Sprintf("the ALB serves HTTPS, %s and %s name its hosted zone", HostedZoneId, HostedZoneName))
+	}
+	zone := i.GetHostedZoneFromId(e.HostedZoneId, e.HostedZoneName)
+	domainName := e.domainName()
+
+	listener := i.AddListener(resource.AddListenerProps{
 		Id:          ListenerName,
-		Port:        80,
+		Port:        443,
 		ALB:         alb,
 		TargetGroup: targetGroup,
+		Certificate: i.NewCertificate(CertificateName, domainName, zone),
 	})
+	// the listener leaves port 80 before the redirect takes it over
+	i.AddRedirectListener(alb, 80, 443).Node().AddDependency(listener)
+	i.NewAliasRecord(RecordName, zone, domainName, alb)
 
 	/*
 		bluetg := i.NewTargetGroup(resource.NewTargetGroupProps{
@@ -254,7 +771,7 @@ func NewInfraStack(scope constructs.Construct, id string, props *InfraStackProps
 
 	pipeline := i.NewCodePipeline(resource.NewCodePipelineProps{
 		Name:   fmt.Sprintf("%sCodePipeline", e.Project),
-		Bucket: pipelineBucket,
+		Bucket: p.PipelineBucket,
 		Stages: []struct {
 			Name   string
 			Action awscodepipeline.IAction
@@ -265,10 +782,9 @@ func NewInfraStack(scope constructs.Construct, id string, props *InfraStackProps
 		},
 	})
 	deployRole.GrantAssumeRole(pipeline.Role())
-
-	return stack
 }
 
+// context keys, main panics listing the required ones that are missing
 const (
 	BootstrapBucketName string = "BBN"
 	ConnectionArn       string = "CARN"
@@ -276,9 +792,31 @@ const (
 	GithubAccessToken   string = "GHAT"
 	GithubOwner         string = "GHO"
 	GithubRepository    string = "GHR"
-	HostedZoneId        string = "HGI"
-	Id                  string = "ID"
-	Project             string = "PROJECT"
+	// the id and name, e.g. example.com, of the Route 53 zone the ALB's HTTPS
+	// certificate and record go in
+	HostedZoneId   string = "HGI"
+	HostedZoneName string = "HGN"
+	Id             string = "ID"
+	Project        string = "PROJECT"
+
+	// optional
+	// defaults to DefaultCorsAllowOrigins, an empty value disables CORS
+	CorsAllowOrigins  string = "CORS"
+	ServiceConnectTls string = "TLS"
+	// "true" deploys the platform and each service in their own stack
+	SplitStacks string = "SPLIT"
+	// NAT instance type, NAT gateways when empty
+	NatInstanceType string = "NAT"
+	// id or comma separated key=value tags of an existing VPC to deploy into
+	ExistingVpcId   string = "VPC"
+	ExistingVpcTags string = "VPCTAGS"
+	// the subdomain of the HGN zone the ALB answers on, the zone apex when empty
+	Subdomain string = "SUBDOMAIN"
+	// "true" prints every security group ingress rule when the app is synthesized
+	IngressReport string = "INGRESS"
+	// "logs" or "s3", and ALL (default), ACCEPT or REJECT
+	FlowLogs        string = "FLOWLOGS"
+	FlowLogsTraffic string = "FLOWLOGSTRAFFIC"
 )
 
 func main() {
@@ -293,37 +831,276 @@ func main() {
 		gho     = app.Node().TryGetContext(jsii.String(GithubOwner))
 		ghr     = app.Node().TryGetContext(jsii.String(GithubRepository))
 		hgi     = app.Node().TryGetContext(jsii.String(HostedZoneId))
+		hgn     = app.Node().TryGetContext(jsii.String(HostedZoneName))
 		id      = app.Node().TryGetContext(jsii.String(Id))
 		project = app.Node().TryGetContext(jsii.String(Project))
+		cors    = app.Node().TryGetContext(jsii.String(CorsAllowOrigins))
+		tls     = app.Node().TryGetContext(jsii.String(ServiceConnectTls))
+		split   = app.Node().TryGetContext(jsii.String(SplitStacks))
+		nat     = app.Node().TryGetContext(jsii.String(NatInstanceType))
+		vpcId   = app.Node().TryGetContext(jsii.String(ExistingVpcId))
+		vpcTags = app.Node().TryGetContext(jsii.String(ExistingVpcTags))
+		flow    = app.Node().TryGetContext(jsii.String(FlowLogs))
+		traffic = app.Node().TryGetContext(jsii.String(FlowLogsTraffic))
+		report  = app.Node().TryGetContext(jsii.String(IngressReport))
+		sub     = app.Node().TryGetContext(jsii.String(Subdomain))
 	)
 
-	if bbn == nil || carn == nil || env == nil || ght == nil || gho == nil || ghr == nil || hgi == nil || project == nil || id == nil {
-		panic("please pass context")
+	if missing := missingContext(map[string]interface{}{
+		BootstrapBucketName: bbn,
+		ConnectionArn:       carn,
+		Env:                 env,
+		GithubAccessToken:   ght,
+		GithubOwner:         gho,
+		GithubRepository:    ghr,
+		HostedZoneId:        hgi,
+		HostedZoneName:      hgn,
+		Id:                  id,
+		Project:             project,
+	}); len(missing) > 0 {
+		panic(fmt.Sprintf("please pass the context %s, e.g. -c %s=<value>", strings.Join(missing, ", "), missing[0]))
 	}
 
 	awscdk.Tags_Of(app).Add(jsii.String("Project"), iToP(project), nil)
-	NewInfraStack(app, fmt.Sprintf("%sStack", project),
-		&InfraStackProps{
-			awscdk.StackProps{
-				Env: myenv(),
-				Synthesizer: awscdk.NewDefaultStackSynthesizer(
-					&awscdk.DefaultStackSynthesizerProps{FileAssetsBucketName: iToP(bbn)},
-				),
-			},
-		},
-		Props{
-			ConnectionArn:    fmt.Sprintf("%s", carn),
-			GithubOwner:      fmt.Sprintf("%s", gho),
-			GithubRepository: fmt.Sprintf("%s", ghr),
-			Project:          fmt.Sprintf("%s", project),
+	props := &InfraStackProps{
+		awscdk.StackProps{
+			Env: myenv(),
+			Synthesizer: awscdk.NewDefaultStackSynthesizer(
+				&awscdk.DefaultStackSynthesizerProps{FileAssetsBucketName: iToP(bbn)},
+			),
 		},
-	)
+	}
+	e := Props{
+		ConnectionArn:     fmt.Sprintf("%s", carn),
+		GithubOwner:       fmt.Sprintf("%s", gho),
+		GithubRepository:  fmt.Sprintf("%s", ghr),
+		Project:           fmt.Sprintf("%s", project),
+		CorsAllowOrigins:  corsAllowOrigins(cors),
+		ServiceConnectTls: optional(tls) == "true",
+		NatInstanceType:   optional(nat),
+		VpcId:             optional(vpcId),
+		VpcTags:           tags(optional(vpcTags)),
+		FlowLogs:          optional(flow),
+		FlowLogsTraffic:   awsec2.FlowLogTrafficType(optional(traffic)),
+		HostedZoneId:      fmt.Sprintf("%s", hgi),
+		HostedZoneName:    fmt.Sprintf("%s", hgn),
+		Subdomain:         optional(sub),
+	}
+	// lookups resolve against the account and region of the CLI's credentials
+	if e.lookups() {
+		props.Env = lookupEnv()
+	}
+
+	var stacks []awscdk.Stack
+	if optional(split) == "true" {
+		platformStack := NewPlatformStack(app, fmt.Sprintf("%sPlatformStack", project), props, e)
+		serverStack := NewServerStack(app, fmt.Sprintf("%sServerStack", project), props, e)
+		clientStack := NewClientStack(app, fmt.Sprintf("%sClientStack", project), props, e)
+
+		// the stacks read the parameters of the stacks they depend on when they are
+		// deployed, there are no exports and each stack updates on its own
+		serverStack.AddDependency(platformStack, jsii.String("platform parameters"))
+		clientStack.AddDependency(platformStack, jsii.String("platform parameters"))
+		clientStack.AddDependency(serverStack, jsii.String("server security group parameter"))
+		stacks = []awscdk.Stack{platformStack, serverStack, clientStack}
+	} else {
+		stacks = []awscdk.Stack{NewInfraStack(app, fmt.Sprintf("%sStack", project), props, e)}
+	}
 
 	app.Synth(nil)
+
+	// once synthesized, the references to other stacks resolve to their exports
+	if optional(report) == "true" {
+		printIngressRules(stacks...)
+	}
+}
+
+// missingContext returns the keys of context that were not passed, sorted.
+func missingContext(context map[string]interface{}) []string {
+	missing := []string{}
+	for k, v := range context {
+		if v == nil {
+			missing = append(missing, k)
+		}
+	}
+	sort.Strings(missing)
+	return missing
 }
 
 func iToP(e interface{}) *string {
 	return jsii.String(fmt.Sprintf("%s", e))
 }
 
+// validateTimeouts panics when a Service Connect proxy would close connections before
+// the load balancer in front of it, or give up on requests before the calling app does.
+func validateTimeouts(services []resource.ServiceConnectService, albIdleTimeout float64, upstreamTimeout float64) {
+	for _, v := range services {
+		perRequest, idle := v.PerRequestTimeout, v.IdleTimeout
+		if perRequest == 0 {
+			perRequest = resource.ServiceConnectPerRequestTimeout
+		}
+		if idle == 0 {
+			idle = resource.ServiceConnectIdleTimeout
+		}
+
+		if idle <= albIdleTimeout {
+			panic(fmt.Sprintf("%s: idle timeout %gs must exceed the ALB idle timeout %gs", v.PortMappingName, idle, albIdleTimeout))
+		}
+		if perRequest <= upstreamTimeout {
+			panic(fmt.Sprintf("%s: per request timeout %gs must exceed the app's UPSTREAM_TIMEOUT %gs", v.PortMappingName, perRequest, upstreamTimeout))
+		}
+	}
+}
+
+// validateFlowLogs panics on a destination or traffic type CloudFormation would
+// only reject at deploy time, empty traffic records ALL.
+func validateFlowLogs(flowLogs string, traffic awsec2.FlowLogTrafficType) {
+	switch flowLogs {
+	case "", FlowLogsToLogs, FlowLogsToS3:
+	default:
+		panic(fmt.Sprintf("flow logs go to %q or %q, not %q", FlowLogsToLogs, FlowLogsToS3, flowLogs))
+	}
+
+	switch traffic {
+	case "", awsec2.FlowLogTrafficType_ALL, awsec2.FlowLogTrafficType_ACCEPT, awsec2.FlowLogTrafficType_REJECT:
+	default:
+		panic(fmt.Sprintf("flow logs traffic is %q, %q or %q, not %q", awsec2.FlowLogTrafficType_ALL, awsec2.FlowLogTrafficType_ACCEPT, awsec2.FlowLogTrafficType_REJECT, traffic))
+	}
+}
+
+// validateHealthCheck panics unless the ALB sees a draining task fail /readyz
+// before the app stops draining, it keeps routing to the task until then.
+func validateHealthCheck(interval float64, timeout float64, threshold float64, drainPeriod float64) {
+	if timeout >= interval {
+		panic(fmt.Sprintf("health check timeout %gs must be less than the interval %gs", timeout, interval))
+	}
+	if interval*threshold >= drainPeriod {
+		panic(fmt.Sprintf("health check interval %gs times threshold %g must be less than the app's DRAIN_PERIOD %gs", interval, threshold, drainPeriod))
+	}
+}
+
+// dependency is an endpoint a service calls through Service Connect, declared by
+// the calling service.
+type dependency struct {
+	Namespace string
+	Host      string
+	Port      float64
+}
+
+// publisher is a service and the Service Connect services it publishes in the
+// namespace it joins.
+type publisher struct {
+	Service      string
+	Namespace    string
+	Services     []resource.ServiceConnectService
+	PortMappings []resource.PortMapping
+}
+
+// endpoint is a dependency resolved to the service publishing it.
+type endpoint struct {
+	dependency
+	Publisher string
+	// the caller reaches the proxy of the publisher's tasks on the container port
+	ContainerPort float64
+}
+
+// resolveDependencies returns the endpoint each dependency of caller calls. It panics
+// when no service publishes a dependency in its namespace, a service only resolves
+// the client aliases of the namespace it joins.
+func resolveDependencies(caller string, publishers []publisher, dependencies []dependency) []endpoint {
+	resolved := []endpoint{}
+	for _, d := range dependencies {
+		found := false
+		for _, p := range publishers {
+			if p.Namespace != d.Namespace {
+				continue
+			}
+			for _, v := range p.Services {
+				port := containerPort(p.PortMappings, v.PortMappingName)
+				alias := v.Port
+				if alias == 0 {
+					alias = port
+				}
+				if !found && v.Host(p.Namespace) == d.Host && alias == d.Port {
+					resolved = append(resolved, endpoint{dependency: d, Publisher: p.Service, ContainerPort: port})
+					found = true
+				}
+			}
+		}
+		if !found {
+			panic(fmt.Sprintf("%s: %s:%g is not published in namespace %q", caller, d.Host, d.Port, d.Namespace))
+		}
+	}
+	return resolved
+}
+
+func containerPort(portMappings []resource.PortMapping, name string) float64 {
+	for _, v := range portMappings {
+		if v.Name == name {
+			return v.Port
+		}
+	}
+	panic(fmt.Sprintf("port mapping %q is not declared", name))
+}
+
+// printIngressRules writes the ingress rules of every stack to stderr, cdk synth
+// prints them above the template.
+func printIngressRules(stacks ...awscdk.Stack) {
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STACK\tSECURITY GROUP\tPORTS\tSOURCE\tDESCRIPTION")
+	for _, stack := range stacks {
+		var i resource.IResourceService = &resource.ResourceService{S: stack}
+		for _, v := range i.IngressRules() {
+			ports := fmt.Sprintf("%s/%g", v.Protocol, v.FromPort)
+			if v.ToPort != v.FromPort {
+				ports = fmt.Sprintf("%s-%g", ports, v.ToPort)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", *stack.StackName(), v.SecurityGroup, ports, v.Source, v.Description)
+		}
+	}
+	w.Flush()
+}
+
+// any origin, what the client allowed before its CORS origins were configurable
+const DefaultCorsAllowOrigins string = "*"
+
+// corsAllowOrigins keeps DefaultCorsAllowOrigins unless the CORS context is passed,
+// browsers calling the client from other origins would be rejected otherwise.
+func corsAllowOrigins(e interface{}) string {
+	if e == nil {
+		return DefaultCorsAllowOrigins
+	}
+	return optional(e)
+}
+
+func optional(e interface{}) string {
+	if e == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s", e)
+}
+
+// tags parses key=value pairs separated by commas.
+func tags(e string) map[string]string {
+	if e == "" {
+		return nil
+	}
+	tmp := map[string]string{}
+	for _, pair := range strings.Split(e, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			panic(fmt.Sprintf("tag %q is not key=value", pair))
+		}
+		tmp[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return tmp
+}
+
 func myenv() *awscdk.Environment { return nil }
+
+func lookupEnv() *awscdk.Environment {
+	return &awscdk.Environment{
+		Account: jsii.String(os.Getenv("CDK_DEFAULT_ACCOUNT")),
+		Region:  jsii.String(os.Getenv("CDK_DEFAULT_REGION")),
+	}
+}
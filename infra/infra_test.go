@@ -1,26 +1,498 @@
 package main
 
-// import (
-// 	"testing"
-
-// 	"github.com/aws/aws-cdk-go/awscdk/v2"
-// 	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
-// 	"github.com/aws/jsii-runtime-go"
-// )
-
-// example tests. To run these tests, uncomment this file along with the
-// example resource in infra_test.go
-// func TestInfraStack(t *testing.T) {
-// 	// GIVEN
-// 	app := awscdk.NewApp(nil)
-
-// 	// WHEN
-// 	stack := NewInfraStack(app, "MyStack", nil)
-
-// 	// THEN
-// 	template := assertions.Template_FromStack(stack)
-
-// 	template.HasResourceProperties(jsii.String("AWS::SQS::Queue"), map[string]interface{}{
-// 		"VisibilityTimeout": 300,
-// 	})
-// }
+import (
+	"encoding/json"
+	resource "infra/resources"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// wantPanic fails the test unless f panics with a message containing want, or
+// panics at all when want is empty.
+func wantPanic(t *testing.T, want string, f func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		r := recover()
+		if want == "" {
+			if r != nil {
+				t.Fatalf("panic = %v, want none", r)
+			}
+			return
+		}
+		if r == nil {
+			t.Fatalf("no panic, want %q", want)
+		}
+		if msg, _ := r.(string); !strings.Contains(msg, want) {
+			t.Fatalf("panic = %v, want %q", r, want)
+		}
+	}()
+	f()
+}
+
+func TestValidateTimeouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []resource.ServiceConnectService
+		alb      float64
+		upstream float64
+		want     string
+	}{
+		{name: "server", services: serverConnectServices, alb: 0, upstream: UpstreamTimeout},
+		{name: "behind the ALB", services: serverConnectServices, alb: AlbIdleTimeout, upstream: UpstreamTimeout},
+		{name: "defaults", services: []resource.ServiceConnectService{{PortMappingName: "a"}}, alb: AlbIdleTimeout, upstream: UpstreamTimeout},
+		{name: "idle equals ALB idle", services: []resource.ServiceConnectService{{PortMappingName: "a", IdleTimeout: 60}}, alb: 60, upstream: UpstreamTimeout, want: "a: idle timeout 60s must exceed the ALB idle timeout 60s"},
+		{name: "idle below ALB idle", services: []resource.ServiceConnectService{{PortMappingName: "a", IdleTimeout: 30}}, alb: 60, upstream: UpstreamTimeout, want: "idle timeout 30s"},
+		{name: "default idle below ALB idle", services: []resource.ServiceConnectService{{PortMappingName: "a"}}, alb: 400, upstream: UpstreamTimeout, want: "idle timeout 300s"},
+		{name: "per request equals upstream", services: []resource.ServiceConnectService{{PortMappingName: "a", PerRequestTimeout: 5}}, upstream: 5, want: "a: per request timeout 5s must exceed the app's UPSTREAM_TIMEOUT 5s"},
+		{name: "default per request below upstream", services: []resource.ServiceConnectService{{PortMappingName: "a"}}, upstream: 20, want: "per request timeout 15s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantPanic(t, tt.want, func() { validateTimeouts(tt.services, tt.alb, tt.upstream) })
+		})
+	}
+}
+
+func TestValidateHealthCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		interval  float64
+		timeout   float64
+		threshold float64
+		want      string
+	}{
+		{name: "deployed", interval: HealthCheckInterval, timeout: HealthCheckTimeout, threshold: HealthCheckThreshold},
+		{name: "timeout equals interval", interval: 5, timeout: 5, threshold: 2, want: "health check timeout 5s must be less than the interval 5s"},
+		{name: "slower than draining", interval: 10, timeout: 5, threshold: 2, want: "health check interval 10s times threshold 2 must be less than the app's DRAIN_PERIOD 15s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantPanic(t, tt.want, func() { validateHealthCheck(tt.interval, tt.timeout, tt.threshold, DrainPeriod) })
+		})
+	}
+}
+
+func TestValidateFlowLogs(t *testing.T) {
+	tests := []struct {
+		name     string
+		flowLogs string
+		traffic  awsec2.FlowLogTrafficType
+		want     string
+	}{
+		{name: "disabled"},
+		{name: "logs, default traffic", flowLogs: FlowLogsToLogs},
+		{name: "s3, rejected traffic", flowLogs: FlowLogsToS3, traffic: awsec2.FlowLogTrafficType_REJECT},
+		{name: "accepted traffic", flowLogs: FlowLogsToLogs, traffic: awsec2.FlowLogTrafficType_ACCEPT},
+		{name: "all traffic", flowLogs: FlowLogsToLogs, traffic: awsec2.FlowLogTrafficType_ALL},
+		{name: "unknown destination", flowLogs: "firehose", want: `flow logs go to "logs" or "s3", not "firehose"`},
+		{name: "unknown traffic", flowLogs: FlowLogsToLogs, traffic: "DENY", want: `flow logs traffic is "ALL", "ACCEPT" or "REJECT", not "DENY"`},
+		{name: "lowercase traffic", flowLogs: FlowLogsToS3, traffic: "reject", want: `not "reject"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantPanic(t, tt.want, func() { validateFlowLogs(tt.flowLogs, tt.traffic) })
+		})
+	}
+}
+
+func TestResolveDependencies(t *testing.T) {
+	publishers := []publisher{
+		{
+			Service:   "server",
+			Namespace: "connect",
+			Services: []resource.ServiceConnectService{
+				{PortMappingName: "http", DnsName: "server.connect", Port: 80},
+				{PortMappingName: "grpc"},
+			},
+			PortMappings: []resource.PortMapping{{Name: "http", Port: 8001}, {Name: "grpc", Port: 9001}},
+		},
+		{
+			Service:      "billing",
+			Namespace:    "billing",
+			Services:     []resource.ServiceConnectService{{PortMappingName: "http", DiscoveryName: "api"}},
+			PortMappings: []resource.PortMapping{{Name: "http", Port: 8080}},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		dependencies []dependency
+		want         []endpoint
+		wantPanic    string
+	}{
+		{name: "none", want: []endpoint{}},
+		{
+			name:         "alias port",
+			dependencies: []dependency{{Namespace: "connect", Host: "server.connect", Port: 80}},
+			want:         []endpoint{{dependency: dependency{Namespace: "connect", Host: "server.connect", Port: 80}, Publisher: "server", ContainerPort: 8001}},
+		},
+		{
+			name:         "default host and port",
+			dependencies: []dependency{{Namespace: "connect", Host: "grpc.connect", Port: 9001}, {Namespace: "billing", Host: "api.billing", Port: 8080}},
+			want: []endpoint{
+				{dependency: dependency{Namespace: "connect", Host: "grpc.connect", Port: 9001}, Publisher: "server", ContainerPort: 9001},
+				{dependency: dependency{Namespace: "billing", Host: "api.billing", Port: 8080}, Publisher: "billing", ContainerPort: 8080},
+			},
+		},
+		{
+			name:         "container port behind an alias port",
+			dependencies: []dependency{{Namespace: "connect", Host: "server.connect", Port: 8001}},
+			wantPanic:    `client: server.connect:8001 is not published in namespace "connect"`,
+		},
+		{
+			name:         "other namespace",
+			dependencies: []dependency{{Namespace: "billing", Host: "server.connect", Port: 80}},
+			wantPanic:    `client: server.connect:80 is not published in namespace "billing"`,
+		},
+		{
+			name:         "unknown namespace",
+			dependencies: []dependency{{Namespace: "local", Host: "grpc.local", Port: 9001}},
+			wantPanic:    `namespace "local"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []endpoint
+			wantPanic(t, tt.wantPanic, func() { got = resolveDependencies("client", publishers, tt.dependencies) })
+			if tt.wantPanic == "" && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveDependencies = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// the deployed client must resolve its upstream
+func TestClientDependencies(t *testing.T) {
+	got := resolveDependencies(ClientServiceName, publishers, clientDependencies)
+	if len(got) != 1 || got[0].Publisher != ServerServiceName || got[0].ContainerPort != ServerGrpcPort {
+		t.Errorf("resolveDependencies = %+v, want the server's gRPC port", got)
+	}
+}
+
+func TestTags(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		want      map[string]string
+		wantPanic string
+	}{
+		{name: "empty", in: "", want: nil},
+		{name: "one", in: "Name=shared", want: map[string]string{"Name": "shared"}},
+		{name: "several with spaces", in: "Name = shared, env=prod", want: map[string]string{"Name": "shared", "env": "prod"}},
+		{name: "empty value", in: "Name=", want: map[string]string{"Name": ""}},
+		{name: "value with equals", in: "k=a=b", want: map[string]string{"k": "a=b"}},
+		{name: "missing value", in: "Name=shared,env", wantPanic: `tag "env" is not key=value`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]string
+			wantPanic(t, tt.wantPanic, func() { got = tags(tt.in) })
+			if tt.wantPanic == "" && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tags(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCorsAllowOrigins(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		want string
+	}{
+		{name: "not passed", in: nil, want: DefaultCorsAllowOrigins},
+		{name: "empty disables CORS", in: "", want: ""},
+		{name: "origins", in: "https://app.example.com,https://*.example.com", want: "https://app.example.com,https://*.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := corsAllowOrigins(tt.in); got != tt.want {
+				t.Errorf("corsAllowOrigins(%v) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// the app reads each caller's secret from the variable config.CallerSecretEnv names
+func TestCallerSecretEnv(t *testing.T) {
+	tests := []struct {
+		caller string
+		want   string
+	}{
+		{caller: ClientContainerName, want: "SERVICE_AUTH_CALLER_SECRET_CLIENT_CONTAINER"},
+		{caller: "billing-api.v2", want: "SERVICE_AUTH_CALLER_SECRET_BILLING_API_V2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.caller, func(t *testing.T) {
+			if got := callerSecretEnv(tt.caller); got != tt.want {
+				t.Errorf("callerSecretEnv(%q) = %q, want %q", tt.caller, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		context map[string]interface{}
+		want    []string
+	}{
+		{name: "all passed", context: map[string]interface{}{HostedZoneId: "Z1", HostedZoneName: "example.com"}, want: []string{}},
+		{name: "zone name missing", context: map[string]interface{}{HostedZoneId: "Z1", HostedZoneName: nil}, want: []string{HostedZoneName}},
+		{name: "sorted", context: map[string]interface{}{Project: nil, HostedZoneName: nil, BootstrapBucketName: nil}, want: []string{BootstrapBucketName, HostedZoneName, Project}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := missingContext(tt.context); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("missingContext = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testProps() Props {
+	return Props{
+		ConnectionArn:     "arn:aws:codestar-connections:ap-northeast-1:123456789012:connection/test",
+		GithubOwner:       "owner",
+		GithubRepository:  "repository",
+		Project:           "p",
+		CorsAllowOrigins:  DefaultCorsAllowOrigins,
+		ServiceConnectTls: true,
+		FlowLogs:          FlowLogsToLogs,
+		FlowLogsTraffic:   awsec2.FlowLogTrafficType_REJECT,
+		HostedZoneId:      "Z0000000000000",
+		HostedZoneName:    "example.com",
+	}
+}
+
+// templateCase runs assert on a synthesized template, the assertions panic when
+// the template does not match.
+type templateCase struct {
+	name   string
+	assert func(assertions.Template)
+}
+
+func runTemplateCases(t *testing.T, template assertions.Template, tests []templateCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Error(r)
+				}
+			}()
+			tt.assert(template)
+		})
+	}
+}
+
+// importsParameter matches the CloudFormation parameter a stack reads an SSM
+// parameter through.
+func importsParameter(name string) func(assertions.Template) {
+	return func(template assertions.Template) {
+		template.HasParameter(jsii.String("*"), map[string]interface{}{
+			"Type":    "AWS::SSM::Parameter::Value<String>",
+			"Default": name,
+		})
+	}
+}
+
+func publishesParameter(name string) func(assertions.Template) {
+	return func(template assertions.Template) {
+		template.HasResourceProperties(jsii.String("AWS::SSM::Parameter"), map[string]interface{}{"Name": name})
+	}
+}
+
+func containerSecrets(container string, secrets ...string) func(assertions.Template) {
+	return func(template assertions.Template) {
+		want := []interface{}{}
+		for _, v := range secrets {
+			want = append(want, map[string]interface{}{"Name": v, "ValueFrom": assertions.Match_AnyValue()})
+		}
+		template.HasResourceProperties(jsii.String("AWS::ECS::TaskDefinition"), map[string]interface{}{
+			"ContainerDefinitions": assertions.Match_ArrayWith(&[]interface{}{
+				assertions.Match_ObjectLike(&map[string]interface{}{"Name": container, "Secrets": want}),
+			}),
+		})
+	}
+}
+
+func TestInfraStackTemplate(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	template := assertions.Template_FromStack(NewInfraStack(app, "Stack", nil, testProps()), nil)
+
+	runTemplateCases(t, template, []templateCase{
+		{
+			name: "service connect timeouts and TLS",
+			assert: func(template assertions.Template) {
+				tls := assertions.Match_ObjectLike(&map[string]interface{}{
+					"IssuerCertificateAuthority": map[string]interface{}{"AwsPcaAuthorityArn": assertions.Match_AnyValue()},
+					"KmsKey":                     assertions.Match_AnyValue(),
+					"RoleArn":                    assertions.Match_AnyValue(),
+				})
+				timeout := map[string]interface{}{"PerRequestTimeoutSeconds": PerRequestTimeout, "IdleTimeoutSeconds": IdleTimeout}
+				template.HasResourceProperties(jsii.String("AWS::ECS::Service"), map[string]interface{}{
+					"ServiceConnectConfiguration": assertions.Match_ObjectLike(&map[string]interface{}{
+						"Services": []interface{}{
+							assertions.Match_ObjectLike(&map[string]interface{}{"PortName": ServerServiceName, "Timeout": timeout, "Tls": tls}),
+							assertions.Match_ObjectLike(&map[string]interface{}{"PortName": ServerGrpcName, "Timeout": timeout, "Tls": tls}),
+						},
+					}),
+				})
+			},
+		},
+		{
+			name: "HTTPS listener",
+			assert: func(template assertions.Template) {
+				template.HasResourceProperties(jsii.String("AWS::ElasticLoadBalancingV2::Listener"), map[string]interface{}{
+					"Port":         443,
+					"Protocol":     "HTTPS",
+					"SslPolicy":    "ELBSecurityPolicy-TLS13-1-2-Res-2021-06",
+					"Certificates": []interface{}{map[string]interface{}{"CertificateArn": assertions.Match_AnyValue()}},
+				})
+			},
+		},
+		{
+			name: "HTTP redirects to HTTPS",
+			assert: func(template assertions.Template) {
+				template.HasResourceProperties(jsii.String("AWS::ElasticLoadBalancingV2::Listener"), map[string]interface{}{
+					"Port":     80,
+					"Protocol": "HTTP",
+					"DefaultActions": []interface{}{map[string]interface{}{
+						"Type":           "redirect",
+						"RedirectConfig": map[string]interface{}{"Protocol": "HTTPS", "Port": "443", "StatusCode": "HTTP_301"},
+					}},
+				})
+			},
+		},
+		{
+			name: "flow log",
+			assert: func(template assertions.Template) {
+				template.ResourceCountIs(jsii.String("AWS::EC2::FlowLog"), jsii.Number(1))
+				template.HasResourceProperties(jsii.String("AWS::EC2::FlowLog"), map[string]interface{}{
+					"ResourceType":       "VPC",
+					"TrafficType":        "REJECT",
+					"LogDestinationType": "cloud-watch-logs",
+				})
+			},
+		},
+		{
+			name: "interface endpoints",
+			assert: func(template assertions.Template) {
+				template.ResourcePropertiesCountIs(jsii.String("AWS::EC2::VPCEndpoint"), map[string]interface{}{
+					"VpcEndpointType":   "Interface",
+					"PrivateDnsEnabled": true,
+				}, jsii.Number(5))
+			},
+		},
+		{
+			name: "S3 gateway endpoint",
+			assert: func(template assertions.Template) {
+				template.ResourcePropertiesCountIs(jsii.String("AWS::EC2::VPCEndpoint"), map[string]interface{}{
+					"VpcEndpointType": "Gateway",
+					"ServiceName":     map[string]interface{}{"Fn::Join": []interface{}{"", []interface{}{"com.amazonaws.", map[string]interface{}{"Ref": "AWS::Region"}, ".s3"}}},
+				}, jsii.Number(1))
+			},
+		},
+		{name: "client signs with its own secret", assert: containerSecrets(ClientContainerName, "SERVICE_AUTH_SECRET")},
+		{name: "server verifies with the client's secret", assert: containerSecrets(ServerContainerName, callerSecretEnv(ClientContainerName))},
+	})
+}
+
+func TestSplitStackTemplates(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	e := testProps()
+	// the first template synthesizes the app, every stack must be in it by then
+	stacks := map[string]awscdk.Stack{
+		"platform": NewPlatformStack(app, "PlatformStack", nil, e),
+		"server":   NewServerStack(app, "ServerStack", nil, e),
+		"client":   NewClientStack(app, "ClientStack", nil, e),
+	}
+	templates := map[string]assertions.Template{}
+	for name, stack := range stacks {
+		templates[name] = assertions.Template_FromStack(stack, nil)
+	}
+	platformParameter := func(name string) string { return PlatformParameter(e.Project, name) }
+	serverSecurityGroup := ServiceParameter(e.Project, ServerServiceName, SecurityGroupIdParameter)
+	clientSecret := platformParameter(CallerParameter(ClientContainerName, ServiceAuthSecretArnParameter))
+	noExports := func(template assertions.Template) {
+		b, err := json.Marshal(template.ToJSON())
+		if err != nil {
+			panic(err)
+		}
+		if s := string(b); strings.Contains(s, "Fn::ImportValue") || strings.Contains(s, `"Export"`) {
+			panic("the template exports or imports values, the stacks share SSM parameters only")
+		}
+	}
+
+	platform := []templateCase{
+		{name: "no services", assert: func(template assertions.Template) {
+			template.ResourceCountIs(jsii.String("AWS::ECS::Service"), jsii.Number(0))
+		}},
+		{name: "no exports", assert: noExports},
+	}
+	for _, v := range []string{
+		VpcIdParameter,
+		PublicSubnetIdsParameter,
+		PrivateSubnetIdsParameter,
+		NamespaceArnParameter,
+		NamespaceIdParameter,
+		NamespaceParameter(ConnectNamespace, NamespaceArnParameter),
+		NamespaceParameter(ConnectNamespace, NamespaceIdParameter),
+		CallerParameter(ClientContainerName, ServiceAuthSecretArnParameter),
+		PrivateCaArnParameter,
+		TlsKeyArnParameter,
+	} {
+		platform = append(platform, templateCase{name: "publishes " + v, assert: publishesParameter(platformParameter(v))})
+	}
+
+	tests := map[string][]templateCase{
+		"platform": platform,
+		"server": {
+			{name: "publishes its security group", assert: publishesParameter(serverSecurityGroup)},
+			{name: "imports the VPC", assert: importsParameter(platformParameter(VpcIdParameter))},
+			{name: "imports the client's secret", assert: importsParameter(clientSecret)},
+			{name: "imports the CA", assert: importsParameter(platformParameter(PrivateCaArnParameter))},
+			{name: "one service, no ALB", assert: func(template assertions.Template) {
+				template.ResourceCountIs(jsii.String("AWS::ECS::Service"), jsii.Number(1))
+				template.ResourceCountIs(jsii.String("AWS::ElasticLoadBalancingV2::LoadBalancer"), jsii.Number(0))
+			}},
+			{name: "no exports", assert: noExports},
+		},
+		"client": {
+			{name: "imports the server's security group", assert: importsParameter(serverSecurityGroup)},
+			{name: "imports its secret", assert: importsParameter(clientSecret)},
+			{name: "imports the connect namespace", assert: importsParameter(platformParameter(NamespaceParameter(ConnectNamespace, NamespaceArnParameter)))},
+			{name: "publishes nothing", assert: func(template assertions.Template) {
+				template.ResourceCountIs(jsii.String("AWS::SSM::Parameter"), jsii.Number(0))
+			}},
+			{name: "owns the rule into the server", assert: func(template assertions.Template) {
+				template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroupIngress"), map[string]interface{}{
+					"FromPort": ServerGrpcPort,
+					"ToPort":   ServerGrpcPort,
+					"GroupId":  map[string]interface{}{"Ref": assertions.Match_AnyValue()},
+				})
+			}},
+			{name: "no exports", assert: noExports},
+		},
+	}
+
+	for name, template := range templates {
+		t.Run(name, func(t *testing.T) { runTemplateCases(t, template, tests[name]) })
+	}
+}
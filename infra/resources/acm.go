@@ -0,0 +1,17 @@
+package resource
+
+import (
+	acm "github.com/aws/aws-cdk-go/awscdk/v2/awscertificatemanager"
+	route53 "github.com/aws/aws-cdk-go/awscdk/v2/awsroute53"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// NewCertificate requests a public certificate for domainName and validates it with
+// records in zone, the stack waits until it is issued.
+func (r *ResourceService) NewCertificate(name string, domainName string, zone route53.IHostedZone) acm.Certificate {
+	return acm.NewCertificate(r.S, jsii.String(name), &acm.CertificateProps{
+		CertificateName: jsii.String(name),
+		DomainName:      jsii.String(domainName),
+		Validation:      acm.CertificateValidation_FromDns(zone),
+	})
+}
@@ -0,0 +1,35 @@
+package resource
+
+import (
+	pca "github.com/aws/aws-cdk-go/awscdk/v2/awsacmpca"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// NewPrivateCa creates a root CA in short-lived certificate mode, which is what
+// Service Connect TLS issues its certificates from, and installs its self-signed
+// certificate so it is active once the stack is deployed.
+func (r *ResourceService) NewPrivateCa(name string, commonName string) PrivateCaReturnValue {
+	ca := pca.NewCfnCertificateAuthority(r.S, jsii.String(name), &pca.CfnCertificateAuthorityProps{
+		Type:             jsii.String("ROOT"),
+		KeyAlgorithm:     jsii.String("RSA_2048"),
+		SigningAlgorithm: jsii.String("SHA256WITHRSA"),
+		UsageMode:        jsii.String("SHORT_LIVED_CERTIFICATE"),
+		Subject:          &pca.CfnCertificateAuthority_SubjectProperty{CommonName: jsii.String(commonName)},
+	})
+
+	certificate := pca.NewCfnCertificate(r.S, jsii.String(name+"-certificate"), &pca.CfnCertificateProps{
+		CertificateAuthorityArn:   ca.AttrArn(),
+		CertificateSigningRequest: ca.AttrCertificateSigningRequest(),
+		SigningAlgorithm:          jsii.String("SHA256WITHRSA"),
+		TemplateArn:               jsii.String("arn:aws:acm-pca:::template/RootCACertificate/V1"),
+		Validity:                  &pca.CfnCertificate_ValidityProperty{Type: jsii.String("YEARS"), Value: jsii.Number(10)},
+	})
+
+	activation := pca.NewCfnCertificateAuthorityActivation(r.S, jsii.String(name+"-activation"), &pca.CfnCertificateAuthorityActivationProps{
+		CertificateAuthorityArn: ca.AttrArn(),
+		Certificate:             certificate.AttrCertificate(),
+		Status:                  jsii.String("ACTIVE"),
+	})
+
+	return PrivateCaReturnValue{CertificateAuthority: ca, Activation: activation}
+}
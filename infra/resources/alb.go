@@ -3,18 +3,18 @@ package resource
 import (
 	"fmt"
 
-	"github.com/aws/aws-cdk-go/awscdk/v2"
 	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
 	lb "github.com/aws/aws-cdk-go/awscdk/v2/awselasticloadbalancingv2"
 	"github.com/aws/jsii-runtime-go"
 )
 
-func (r *ResourceService) NewAlb(name string, vpc ec2.Vpc) lb.ApplicationLoadBalancer {
+func (r *ResourceService) NewAlb(name string, vpc ec2.IVpc, idleTimeout float64) lb.ApplicationLoadBalancer {
 	return lb.NewApplicationLoadBalancer(r.S, jsii.String(name), &lb.ApplicationLoadBalancerProps{
 		Vpc:              vpc,
 		InternetFacing:   jsii.Bool(true),
 		LoadBalancerName: jsii.String(name),
 		VpcSubnets:       &ec2.SubnetSelection{Subnets: vpc.PublicSubnets()},
+		IdleTimeout:      optionalSeconds(idleTimeout),
 	})
 }
 
@@ -25,18 +25,46 @@ func (r *ResourceService) NewTargetGroup(e NewTargetGroupProps) lb.ApplicationTa
 		Port:            jsii.Number(80),
 		Vpc:             e.Vpc,
 		Targets:         &[]lb.IApplicationLoadBalancerTarget{e.Service},
+		// should match the app's DRAIN_PERIOD
+		DeregistrationDelay: optionalSeconds(e.DeregistrationDelay),
+		// /readyz does not check the app's upstreams, an upstream outage must not
+		// take every task out of the target group
 		HealthCheck: &lb.HealthCheck{
-			Path:     jsii.String("/hc"),
-			Port:     jsii.String(fmt.Sprintf("%g", e.Port)),
-			Interval: awscdk.Duration_Seconds(jsii.Number(300)),
+			Path:                    jsii.String("/readyz"),
+			Port:                    jsii.String(fmt.Sprintf("%g", e.Port)),
+			Interval:                optionalSeconds(e.HealthCheckInterval),
+			Timeout:                 optionalSeconds(e.HealthCheckTimeout),
+			HealthyThresholdCount:   optionalNumber(e.HealthCheckThreshold),
+			UnhealthyThresholdCount: optionalNumber(e.HealthCheckThreshold),
 		},
 	})
 }
 
+// AddListener serves HTTPS with Certificate when it is set, plain HTTP otherwise.
 func (r *ResourceService) AddListener(e AddListenerProps) lb.ApplicationListener {
-	return e.ALB.AddListener(jsii.String(e.Id), &lb.BaseApplicationListenerProps{
+	props := &lb.BaseApplicationListenerProps{
 		Protocol:            lb.ApplicationProtocol_HTTP,
 		Port:                jsii.Number(e.Port),
 		DefaultTargetGroups: &[]lb.IApplicationTargetGroup{e.TargetGroup},
+	}
+	if e.Certificate != nil {
+		props.Protocol = lb.ApplicationProtocol_HTTPS
+		props.Certificates = &[]lb.IListenerCertificate{lb.ListenerCertificate_FromCertificateManager(e.Certificate)}
+		// ELBSecurityPolicy-TLS13-1-2-Res-2021-06, TLS 1.3 and 1.2 with forward
+		// secrecy only
+		props.SslPolicy = lb.SslPolicy_TLS13_RES
+	}
+
+	return e.ALB.AddListener(jsii.String(e.Id), props)
+}
+
+// AddRedirectListener answers HTTP on port from with a permanent redirect to HTTPS
+// on port to.
+func (r *ResourceService) AddRedirectListener(alb lb.ApplicationLoadBalancer, from float64, to float64) lb.ApplicationListener {
+	return alb.AddRedirect(&lb.ApplicationLoadBalancerRedirectConfig{
+		SourceProtocol: lb.ApplicationProtocol_HTTP,
+		SourcePort:     jsii.Number(from),
+		TargetProtocol: lb.ApplicationProtocol_HTTPS,
+		TargetPort:     jsii.Number(to),
 	})
 }
@@ -3,14 +3,17 @@ package resource
 import (
 	"fmt"
 
+	"github.com/aws/aws-cdk-go/awscdk/v2"
 	as "github.com/aws/aws-cdk-go/awscdk/v2/awsapplicationautoscaling"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
 	ecs "github.com/aws/aws-cdk-go/awscdk/v2/awsecs"
+	iam "github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	sd "github.com/aws/aws-cdk-go/awscdk/v2/awsservicediscovery"
 	"github.com/aws/jsii-runtime-go"
 )
 
-func (r *ResourceService) NewCluster(e NewClusterProps) ecs.Cluster {
-	return ecs.NewCluster(r.S, jsii.String(e.ClusterName),
+func (r *ResourceService) NewCluster(e NewClusterProps) ClusterReturnValue {
+	cluster := ecs.NewCluster(r.S, jsii.String(e.ClusterName),
 		&ecs.ClusterProps{
 			Vpc:         e.Vpc,
 			ClusterName: jsii.String(e.ClusterName),
@@ -31,6 +34,43 @@ func (r *ResourceService) NewCluster(e NewClusterProps) ecs.Cluster {
 			},
 		},
 	)
+
+	// the cluster waits for these so its services do too
+	namespaces := map[string]sd.INamespace{}
+	for _, v := range e.AdditionalNamespaces {
+		namespace := sd.NewHttpNamespace(r.S, jsii.String(v), &sd.HttpNamespaceProps{
+			Name: jsii.String(v),
+		})
+		cluster.Node().AddDependency(namespace)
+		namespaces[v] = namespace
+	}
+
+	return ClusterReturnValue{Cluster: cluster, Namespaces: namespaces}
+}
+
+// GetClusterFromName imports a cluster created by NewCluster and its namespaces,
+// services join the default one unless they name another one.
+func (r *ResourceService) GetClusterFromName(e GetClusterFromNameProps) ClusterReturnValue {
+	importNamespace := func(v NamespaceAttributes) sd.INamespace {
+		return sd.HttpNamespace_FromHttpNamespaceAttributes(r.S, jsii.String(v.Name), &sd.HttpNamespaceAttributes{
+			NamespaceName: jsii.String(v.Name),
+			NamespaceArn:  v.Arn,
+			NamespaceId:   v.Id,
+		})
+	}
+
+	cluster := ecs.Cluster_FromClusterAttributes(r.S, jsii.String(e.ClusterName), &ecs.ClusterAttributes{
+		ClusterName:              jsii.String(e.ClusterName),
+		DefaultCloudMapNamespace: importNamespace(e.NameSpace),
+		Vpc:                      e.Vpc,
+	})
+
+	namespaces := map[string]sd.INamespace{}
+	for _, v := range e.AdditionalNamespaces {
+		namespaces[v.Name] = importNamespace(v)
+	}
+
+	return ClusterReturnValue{Cluster: cluster, Namespaces: namespaces}
 }
 
 func (r *ResourceService) NewTaskDefinition(taskName string) ecs.FargateTaskDefinition {
@@ -43,28 +83,209 @@ func (r *ResourceService) NewTaskDefinition(taskName string) ecs.FargateTaskDefi
 }
 
 func (r *ResourceService) AddContainer(e AddContainerProps) ecs.ContainerDefinition {
-	return e.Task.AddContainer(jsii.String(e.ContainerName),
+	// the task is 256 cpu / 512 MiB, the collector sidecar takes its share out of it
+	cpu, memory := float64(256), float64(512)
+	if e.Collector != nil {
+		cpu, memory = cpu-CollectorCpu, memory-CollectorMemory
+	}
+
+	if len(e.PortMappings) == 0 {
+		panic(fmt.Sprintf("%s: at least one port mapping is required", e.ContainerName))
+	}
+	portMappings := []*ecs.PortMapping{}
+	names, ports := map[string]bool{}, map[float64]bool{}
+	for _, v := range e.PortMappings {
+		if names[v.Name] || ports[v.Port] {
+			panic(fmt.Sprintf("%s: port mapping %q (%g) is declared twice", e.ContainerName, v.Name, v.Port))
+		}
+		names[v.Name], ports[v.Port] = true, true
+
+		appProtocol := v.AppProtocol
+		if appProtocol == nil {
+			appProtocol = ecs.AppProtocol_Http()
+		}
+		portMappings = append(portMappings, &ecs.PortMapping{
+			AppProtocol:   appProtocol,
+			Name:          jsii.String(v.Name),
+			Protocol:      ecs.Protocol_TCP,
+			HostPort:      jsii.Number(v.Port),
+			ContainerPort: jsii.Number(v.Port),
+		})
+	}
+
+	container := e.Task.AddContainer(jsii.String(e.ContainerName),
 		&ecs.ContainerDefinitionOptions{
 			ContainerName:  jsii.String(e.ContainerName),
-			Cpu:            jsii.Number(256),
-			MemoryLimitMiB: jsii.Number(512),
+			Cpu:            jsii.Number(cpu),
+			MemoryLimitMiB: jsii.Number(memory),
 			Image:          e.Image,
-			PortMappings: &[]*ecs.PortMapping{
-				{
-					AppProtocol:   ecs.AppProtocol_Http(),
-					Name:          jsii.String(e.PortMappingName), // == ServiceConnectConfiguration.Services.PortMappingName
-					Protocol:      ecs.Protocol_TCP,
-					HostPort:      jsii.Number(e.Port),
-					ContainerPort: jsii.Number(e.Port),
-				},
+			PortMappings:   &portMappings,
+			// liveness only, readiness (/readyz) is checked by the target group
+			HealthCheck: &ecs.HealthCheck{
+				Command:     &[]*string{jsii.String("CMD"), jsii.String("/main"), jsii.String("healthcheck")},
+				Interval:    awscdk.Duration_Seconds(jsii.Number(30)),
+				Timeout:     awscdk.Duration_Seconds(jsii.Number(5)),
+				Retries:     jsii.Number(3),
+				StartPeriod: awscdk.Duration_Seconds(jsii.Number(10)),
 			},
+			// time between SIGTERM and SIGKILL, must cover the app's drain period and shutdown timeout
+			StopTimeout: optionalSeconds(e.StopTimeout),
 			Logging:     ecs.LogDriver_AwsLogs(&ecs.AwsLogDriverProps{StreamPrefix: jsii.String(e.ContainerName), LogGroup: e.LogGroup}),
 			Environment: &e.Env,
+			Secrets:     &e.Secrets,
 		},
 	)
+
+	if e.Collector != nil {
+		collector := addCollector(e)
+
+		if e.Collector.Traces {
+			container.AddEnvironment(jsii.String("OTEL_EXPORTER_OTLP_ENDPOINT"), jsii.String("http://localhost:4318"))
+			container.AddEnvironment(jsii.String("OTEL_SERVICE_NAME"), jsii.String(e.ContainerName))
+			container.AddContainerDependencies(&ecs.ContainerDependency{
+				Container: collector,
+				Condition: ecs.ContainerDependencyCondition_START,
+			})
+		}
+	}
+
+	return container
 }
 
+const (
+	CollectorImage  string  = "public.ecr.aws/aws-observability/aws-otel-collector:v0.35.0"
+	CollectorCpu    float64 = 64
+	CollectorMemory float64 = 128
+)
+
+// https://aws-otel.github.io/docs/getting-started/container-insights/ecs-prometheus
+// https://aws-otel.github.io/docs/getting-started/x-ray
+func addCollector(e AddContainerProps) ecs.ContainerDefinition {
+	receivers, exporters, pipelines := "", "", ""
+
+	if e.Collector.Metrics {
+		if e.Collector.MetricsPort == 0 {
+			panic(fmt.Sprintf("%s: the collector needs the MetricsPort to scrape", e.ContainerName))
+		}
+		receivers += fmt.Sprintf(`
+  prometheus:
+    config:
+      scrape_configs:
+        - job_name: %[1]s
+          scrape_interval: 60s
+          metrics_path: /metrics
+          static_configs:
+            - targets: ["localhost:%[2]g"]`, e.ContainerName, e.Collector.MetricsPort)
+		exporters += fmt.Sprintf(`
+  awsemf:
+    namespace: %s
+    log_group_name: %s
+    log_stream_name: %s-metrics
+    dimension_rollup_option: NoDimensionRollup
+    metric_declarations:
+      - dimensions: [[route, status]]
+        metric_name_selectors: ["^http_request.*"]
+      - dimensions: [[target]]
+        metric_name_selectors: ["^upstream_.*"]
+      - dimensions: [[method, code]]
+        metric_name_selectors: ["^grpc_request.*"]`, e.Collector.MetricsNamespace, *e.LogGroup.LogGroupName(), e.ContainerName)
+		pipelines += `
+    metrics:
+      receivers: [prometheus]
+      exporters: [awsemf]`
+
+		e.LogGroup.GrantWrite(e.Task.TaskRole())
+		e.Task.AddToTaskRolePolicy(iam.NewPolicyStatement(&iam.PolicyStatementProps{
+			Actions:   vToP([]string{"logs:DescribeLogStreams", "logs:DescribeLogGroups"}),
+			Effect:    iam.Effect_ALLOW,
+			Resources: vToP([]string{*e.LogGroup.LogGroupArn()}),
+		}))
+	}
+
+	if e.Collector.Traces {
+		receivers += `
+  otlp:
+    protocols:
+      http:
+        endpoint: 0.0.0.0:4318`
+		exporters += `
+  awsxray:`
+		pipelines += `
+    traces:
+      receivers: [otlp]
+      exporters: [awsxray]`
+
+		e.Task.AddToTaskRolePolicy(iam.NewPolicyStatement(&iam.PolicyStatementProps{
+			Actions:   vToP([]string{"xray:PutTraceSegments", "xray:PutTelemetryRecords", "xray:GetSamplingRules", "xray:GetSamplingTargets"}),
+			Effect:    iam.Effect_ALLOW,
+			Resources: vToP([]string{"*"}),
+		}))
+	}
+
+	config := fmt.Sprintf("receivers:%s\nexporters:%s\nservice:\n  pipelines:%s\n", receivers, exporters, pipelines)
+
+	return e.Task.AddContainer(jsii.String(fmt.Sprintf("%s-collector", e.ContainerName)),
+		&ecs.ContainerDefinitionOptions{
+			ContainerName:  jsii.String(fmt.Sprintf("%s-collector", e.ContainerName)),
+			Cpu:            jsii.Number(CollectorCpu),
+			MemoryLimitMiB: jsii.Number(CollectorMemory),
+			Essential:      jsii.Bool(false),
+			Image:          ecs.ContainerImage_FromRegistry(jsii.String(CollectorImage), nil),
+			Logging:        ecs.LogDriver_AwsLogs(&ecs.AwsLogDriverProps{StreamPrefix: jsii.String(fmt.Sprintf("%s-collector", e.ContainerName)), LogGroup: e.LogGroup}),
+			Environment:    &map[string]*string{"AOT_CONFIG_CONTENT": jsii.String(config)},
+		},
+	)
+}
+
+// seconds, what Service Connect applies to HTTP, HTTP/2 and gRPC ports without a timeout
+const (
+	ServiceConnectPerRequestTimeout float64 = 15
+	ServiceConnectIdleTimeout       float64 = 300
+)
+
 func (r *ResourceService) NewService(e NewServiceProps) ecs.FargateService {
+	if e.Tls != nil && len(e.ServiceConnectServices) == 0 {
+		panic(fmt.Sprintf("%s: Service Connect TLS needs at least one published port", e.ServiceName))
+	}
+
+	// nil leaves the service in client only mode
+	var services []*ecs.ServiceConnectService
+	discoveryNames := map[string]bool{}
+	for _, v := range e.ServiceConnectServices {
+		portMapping := e.TaskDefinition.FindPortMappingByName(jsii.String(v.PortMappingName))
+		if portMapping == nil {
+			panic(fmt.Sprintf("%s: port mapping %q is not declared by the task definition", e.ServiceName, v.PortMappingName))
+		}
+
+		service := &ecs.ServiceConnectService{
+			PortMappingName: jsii.String(v.PortMappingName),
+			DiscoveryName:   jsii.String(v.PortMappingName),
+			Port:            portMapping.ContainerPort,
+		}
+		if v.DiscoveryName != "" {
+			service.DiscoveryName = jsii.String(v.DiscoveryName)
+		}
+		if v.DnsName != "" {
+			service.DnsName = jsii.String(v.DnsName)
+		}
+		if v.Port != 0 {
+			service.Port = jsii.Number(v.Port)
+		}
+
+		if v.PerRequestTimeout < 0 || v.IdleTimeout < 0 {
+			panic(fmt.Sprintf("%s: %s timeouts must not be negative", e.ServiceName, v.PortMappingName))
+		}
+		if v.PerRequestTimeout > 0 && v.IdleTimeout > 0 && v.PerRequestTimeout > v.IdleTimeout {
+			panic(fmt.Sprintf("%s: %s per request timeout %gs exceeds its idle timeout %gs", e.ServiceName, v.PortMappingName, v.PerRequestTimeout, v.IdleTimeout))
+		}
+
+		if discoveryNames[*service.DiscoveryName] {
+			panic(fmt.Sprintf("%s: discovery name %q is used twice", e.ServiceName, *service.DiscoveryName))
+		}
+		discoveryNames[*service.DiscoveryName] = true
+		services = append(services, service)
+	}
+
 	service := ecs.NewFargateService(r.S, jsii.String(e.ServiceName), &ecs.FargateServiceProps{
 		Cluster:              e.Cluster,
 		CircuitBreaker:       &ecs.DeploymentCircuitBreaker{Rollback: jsii.Bool(true)},
@@ -73,6 +294,7 @@ func (r *ResourceService) NewService(e NewServiceProps) ecs.FargateService {
 		ServiceName:          jsii.String(e.ServiceName),
 		TaskDefinition:       e.TaskDefinition,
 		VpcSubnets:           &awsec2.SubnetSelection{Subnets: &e.Subnets},
+		SecurityGroups:       securityGroupsOrNil(e.SecurityGroups),
 		/*
 			https://docs.aws.amazon.com/AmazonECS/latest/developerguide/deployment-types.html
 			ecs.DeploymentControllerType_ECS → rolling update
@@ -80,13 +302,8 @@ func (r *ResourceService) NewService(e NewServiceProps) ecs.FargateService {
 		*/
 		DeploymentController: &ecs.DeploymentController{Type: ecs.DeploymentControllerType_ECS},
 		ServiceConnectConfiguration: &ecs.ServiceConnectProps{
-			Services: &[]*ecs.ServiceConnectService{
-				{
-					DiscoveryName:   jsii.String(e.ServiceName),
-					Port:            jsii.Number(e.Port),
-					PortMappingName: jsii.String(e.ServiceName),
-				},
-			},
+			Namespace: optionalString(e.Namespace),
+			Services:  servicesOrNil(services),
 			LogDriver: ecs.LogDriver_AwsLogs(&ecs.AwsLogDriverProps{
 				StreamPrefix: jsii.String(fmt.Sprintf("service-connect/%s", e.ServiceName)),
 				LogGroup:     e.LogGroup,
@@ -94,6 +311,27 @@ func (r *ResourceService) NewService(e NewServiceProps) ecs.FargateService {
 		},
 	})
 
+	// ServiceConnectService has no timeout or TLS settings in this CDK version
+	cfn := service.Node().DefaultChild().(ecs.CfnService)
+	if e.Tls != nil && e.Tls.Activation != nil {
+		service.Node().AddDependency(e.Tls.Activation)
+	}
+	for i, v := range e.ServiceConnectServices {
+		if e.Tls != nil {
+			cfn.AddPropertyOverride(jsii.String(fmt.Sprintf("ServiceConnectConfiguration.Services.%d.Tls", i)), map[string]interface{}{
+				"IssuerCertificateAuthority": map[string]interface{}{"AwsPcaAuthorityArn": e.Tls.CertificateAuthorityArn},
+				"KmsKey":                     e.Tls.KmsKey.KeyArn(),
+				"RoleArn":                    e.Tls.Role.RoleArn(),
+			})
+		}
+		if v.PerRequestTimeout > 0 {
+			cfn.AddPropertyOverride(jsii.String(fmt.Sprintf("ServiceConnectConfiguration.Services.%d.Timeout.PerRequestTimeoutSeconds", i)), jsii.Number(v.PerRequestTimeout))
+		}
+		if v.IdleTimeout > 0 {
+			cfn.AddPropertyOverride(jsii.String(fmt.Sprintf("ServiceConnectConfiguration.Services.%d.Timeout.IdleTimeoutSeconds", i)), jsii.Number(v.IdleTimeout))
+		}
+	}
+
 	if e.MaxCount != nil {
 		taskCount := service.AutoScaleTaskCount(&as.EnableScalingProps{
 			MaxCapacity: e.MaxCount,
@@ -107,6 +345,32 @@ func (r *ResourceService) NewService(e NewServiceProps) ecs.FargateService {
 	return service
 }
 
+func securityGroupsOrNil(securityGroups []awsec2.ISecurityGroup) *[]awsec2.ISecurityGroup {
+	if len(securityGroups) == 0 {
+		return nil
+	}
+	return &securityGroups
+}
+
+func servicesOrNil(services []*ecs.ServiceConnectService) *[]*ecs.ServiceConnectService {
+	if len(services) == 0 {
+		return nil
+	}
+	return &services
+}
+
+// Host is the name other services of the namespace call this one with.
+func (s ServiceConnectService) Host(namespace string) string {
+	switch {
+	case s.DnsName != "":
+		return s.DnsName
+	case s.DiscoveryName != "":
+		return fmt.Sprintf("%s.%s", s.DiscoveryName, namespace)
+	default:
+		return fmt.Sprintf("%s.%s", s.PortMappingName, namespace)
+	}
+}
+
 func (r *ResourceService) NewServiceConnection(e NewServiceConnectionProps) {
-	e.ToConnection.AllowFrom(e.FromConnection, awsec2.Port_Tcp(jsii.Number(e.ToPort)), nil)
+	e.ToConnection.AllowFrom(e.FromConnection, awsec2.Port_Tcp(jsii.Number(e.ToPort)), optionalString(e.Description))
 }
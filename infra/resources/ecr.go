@@ -12,3 +12,7 @@ func (r *ResourceService) NewEcrRepository(repositoryName string) ecr.Repository
 		RepositoryName:     jsii.String(repositoryName),
 	})
 }
+
+func (r *ResourceService) GetEcrRepositoryFromName(repositoryName string) ecr.IRepository {
+	return ecr.Repository_FromRepositoryName(r.S, jsii.String(repositoryName), jsii.String(repositoryName))
+}
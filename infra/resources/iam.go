@@ -2,6 +2,7 @@ package resource
 
 import (
 	iam "github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	kms "github.com/aws/aws-cdk-go/awscdk/v2/awskms"
 	"github.com/aws/jsii-runtime-go"
 )
 
@@ -32,3 +33,19 @@ func (r *ResourceService) AttachPolicyToRole(policyName string, actions []string
 		})},
 	})
 }
+
+// NewServiceConnectTlsRole lets ECS issue certificates from the private CA and
+// encrypt their private keys with key.
+func (r *ResourceService) NewServiceConnectTlsRole(name string, key kms.IKey) iam.Role {
+	role := r.NewAssumeRole(name, "ecs.amazonaws.com",
+		[]string{"kms:Encrypt", "kms:Decrypt", "kms:GenerateDataKey", "kms:GenerateDataKeyPair", "kms:DescribeKey"},
+		[]string{*key.KeyArn()},
+	)
+	role.AddManagedPolicy(iam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("service-role/AmazonECSInfrastructureRolePolicyForServiceConnectTransportLayerSecurity")))
+
+	return role
+}
+
+func (r *ResourceService) GetRoleFromName(name string) iam.IRole {
+	return iam.Role_FromRoleName(r.S, jsii.String(name), jsii.String(name), nil)
+}
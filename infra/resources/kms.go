@@ -27,6 +27,8 @@ func (r *ResourceService) NewKey(name string, principal string) kms.Key {
 	})
 }
 
-func (r *ResourceService) GetKeyFromName(name string) kms.IKey {
-	return kms.Alias_FromAliasName(r.S, jsii.String(name), jsii.String(name))
+// GetKeyFromArn imports a key by ARN, grants and policies the imported key adds
+// name the key itself rather than its alias.
+func (r *ResourceService) GetKeyFromArn(name string, arn *string) kms.IKey {
+	return kms.Key_FromKeyArn(r.S, jsii.String(name), arn)
 }
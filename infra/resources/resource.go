@@ -2,6 +2,8 @@ package resource
 
 import (
 	cdk "github.com/aws/aws-cdk-go/awscdk/v2"
+	pca "github.com/aws/aws-cdk-go/awscdk/v2/awsacmpca"
+	acm "github.com/aws/aws-cdk-go/awscdk/v2/awscertificatemanager"
 	pipeline "github.com/aws/aws-cdk-go/awscdk/v2/awscodepipeline"
 	actions "github.com/aws/aws-cdk-go/awscdk/v2/awscodepipelineactions"
 	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
@@ -11,7 +13,11 @@ import (
 	iam "github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
 	kms "github.com/aws/aws-cdk-go/awscdk/v2/awskms"
 	logs "github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
+	route53 "github.com/aws/aws-cdk-go/awscdk/v2/awsroute53"
 	s3 "github.com/aws/aws-cdk-go/awscdk/v2/awss3"
+	sm "github.com/aws/aws-cdk-go/awscdk/v2/awssecretsmanager"
+	sd "github.com/aws/aws-cdk-go/awscdk/v2/awsservicediscovery"
+	ssm "github.com/aws/aws-cdk-go/awscdk/v2/awsssm"
 )
 
 type ResourceService struct {
@@ -19,10 +25,17 @@ type ResourceService struct {
 }
 
 type IResourceService interface {
+	// acm.go
+	NewCertificate(name string, domainName string, zone route53.IHostedZone) acm.Certificate
+
+	// acmpca.go
+	NewPrivateCa(name string, commonName string) PrivateCaReturnValue
+
 	// alb.go
-	NewAlb(name string, vpc ec2.Vpc) lb.ApplicationLoadBalancer
+	NewAlb(name string, vpc ec2.IVpc, idleTimeout float64) lb.ApplicationLoadBalancer
 	NewTargetGroup(e NewTargetGroupProps) lb.ApplicationTargetGroup
 	AddListener(e AddListenerProps) lb.ApplicationListener
+	AddRedirectListener(alb lb.ApplicationLoadBalancer, from float64, to float64) lb.ApplicationListener
 
 	// cloudwatch.go
 	NewLogGroup(name string, key kms.IKey) logs.LogGroup
@@ -36,7 +49,8 @@ type IResourceService interface {
 	NewBlueGreenDeployAction(e NewBlueGreenDeployActionProps) actions.CodeDeployEcsDeployAction
 
 	// container.go
-	NewCluster(e NewClusterProps) ecs.Cluster
+	NewCluster(e NewClusterProps) ClusterReturnValue
+	GetClusterFromName(e GetClusterFromNameProps) ClusterReturnValue
 	NewTaskDefinition(taskName string) ecs.FargateTaskDefinition
 	AddContainer(e AddContainerProps) ecs.ContainerDefinition
 	NewService(e NewServiceProps) ecs.FargateService
@@ -44,46 +58,223 @@ type IResourceService interface {
 
 	// ecr.go
 	NewEcrRepository(repositoryName string) ecr.Repository
+	GetEcrRepositoryFromName(repositoryName string) ecr.IRepository
 
 	// iam.go
 	NewAssumeRole(name string, principal string, actions []string, resources []string) iam.Role
 	AttachPolicyToRole(policyName string, actions []string, resources []string, role *iam.IRole) iam.Policy
+	NewServiceConnectTlsRole(name string, key kms.IKey) iam.Role
+	GetRoleFromName(name string) iam.IRole
 
 	// kms.go
 	NewKey(name string, principal string) kms.Key
-	GetKeyFromName(name string) kms.IKey
+	GetKeyFromArn(name string, arn *string) kms.IKey
+
+	// route53.go
+	GetHostedZoneFromId(id string, zoneName string) route53.IHostedZone
+	NewAliasRecord(name string, zone route53.IHostedZone, recordName string, alb lb.IApplicationLoadBalancer) route53.ARecord
 
 	//s3.go
 	NewBucket(name string) s3.Bucket
 	GetBucketFromName(name string) s3.IBucket
 
+	// secretsmanager.go
+	NewSecret(name string) sm.Secret
+	GetSecretFromArn(name string, arn *string) sm.ISecret
+
+	// ssm.go
+	NewParameter(name string, value *string) ssm.StringParameter
+	GetParameterFromName(name string) *string
+
 	// vpc.go
-	NewVpc(vpcName string, cidr string) ec2.Vpc
+	NewVpc(e NewVpcProps) ec2.Vpc
+	GetVpcFromAttributes(vpcName string, e GetVpcFromAttributesProps) ec2.IVpc
+	LookupVpc(vpcName string, e LookupVpcProps) ec2.IVpc
+	NewFlowLog(e NewFlowLogProps) ec2.FlowLog
+	NewSecurityGroup(e NewSecurityGroupProps) ec2.SecurityGroup
+	GetSecurityGroupFromId(name string, id *string) ec2.ISecurityGroup
+	IngressRules() []IngressRule
+}
+
+type NewVpcProps struct {
+	VpcName string
+	Cidr    string
+	// 0 keeps CDK's default of up to 3 availability zones
+	MaxAzs float64
+	// nil keeps one NAT gateway per availability zone, fewer are cheaper but shared across zones
+	NatGateways *float64
+	// e.g. t3.nano, runs NAT instances instead of gateways. Cheaper for dev, their AMI
+	// is looked up when the app is synthesized, so the stack must set its account and
+	// region or synth panics.
+	NatInstanceType string
+	// adds a subnet tier with no route to the internet, e.g. for databases
+	IsolatedSubnets bool
+	// keyed by construct id, routed from every subnet
+	GatewayEndpoints map[string]ec2.GatewayVpcEndpointAwsService
+	// reachable from the private subnets, the tasks reach these services without the NAT
+	InterfaceEndpoints []ec2.InterfaceVpcEndpointAwsService
+}
+
+type NewSecurityGroupProps struct {
+	Name        string
+	Description string
+	Vpc         ec2.IVpc
+	// tcp ports open to anywhere, the group allows no other egress than the rules
+	// connections add
+	EgressPorts []float64
+}
+
+type IngressRule struct {
+	SecurityGroup string
+	Source        string
+	Protocol      string
+	FromPort      float64
+	ToPort        float64
+	Description   string
+}
+
+type NewFlowLogProps struct {
+	Name string
+	Vpc  ec2.IVpc
+	// defaults to ALL, ACCEPT and REJECT keep what security groups let in or drop
+	TrafficType ec2.FlowLogTrafficType
+
+	// one of LogGroup or Bucket
+	LogGroup logs.ILogGroup
+	Bucket   s3.IBucket
+	// key prefix in Bucket
+	Prefix string
+}
+
+type LookupVpcProps struct {
+	VpcId string
+	// every tag must match, e.g. the network team's Name tag
+	Tags map[string]string
+}
+
+type GetVpcFromAttributesProps struct {
+	VpcId *string
+	// one subnet of each tier per availability zone, in the same order
+	AvailabilityZones []*string
+	PublicSubnetIds   []*string
+	PrivateSubnetIds  []*string
 }
 
 type NewClusterProps struct {
 	ClusterName string
-	NameSpace   string
+	// the default Service Connect namespace
+	NameSpace string
+	// HTTP namespaces services can join instead, e.g. one per domain or team
+	AdditionalNamespaces []string
 
 	LogBucket s3.IBucket
 	LogGroup  logs.ILogGroup
 	Vpc       ec2.IVpc
 }
 
+type ClusterReturnValue struct {
+	Cluster ecs.ICluster
+	// the additional namespaces by name, services join one with its ARN
+	Namespaces map[string]sd.INamespace
+}
+
+type GetClusterFromNameProps struct {
+	ClusterName string
+	// the cluster's default Service Connect namespace
+	NameSpace            NamespaceAttributes
+	AdditionalNamespaces []NamespaceAttributes
+
+	Vpc ec2.IVpc
+}
+
+type NamespaceAttributes struct {
+	Name string
+	Arn  *string
+	Id   *string
+}
+
 type AddContainerProps struct {
-	ContainerName   string
-	Env             map[string]*string
-	Port            float64
-	PortMappingName string
+	ContainerName string
+	Env           map[string]*string
+	Secrets       map[string]ecs.Secret
+	// at least one, names and ports unique. The container health check runs
+	// `/main healthcheck`, which calls /livez on the app's PORT whichever these are
+	PortMappings []PortMapping
+	// seconds, 0 keeps the Fargate default of 30
+	StopTimeout float64
 
 	Image    ecs.ContainerImage
 	LogGroup logs.ILogGroup
 	Task     ecs.TaskDefinition
+
+	// optional ADOT collector sidecar, nil to run the container alone
+	Collector *CollectorProps
+}
+
+type PortMapping struct {
+	// == ServiceConnectConfiguration.Services.PortMappingName
+	Name string
+	Port float64
+	// protocol Service Connect speaks to the container, defaults to HTTP/1.1
+	AppProtocol ecs.AppProtocol
+}
+
+type ServiceConnectService struct {
+	// must name one of the task definition's port mappings
+	PortMappingName string
+	// Cloud Map service name, defaults to PortMappingName
+	DiscoveryName string
+	// client alias, defaults to <DiscoveryName>.<namespace>
+	DnsName string
+	// client alias port, defaults to the container port
+	Port float64
+	// seconds, 0 keeps ServiceConnectPerRequestTimeout and ServiceConnectIdleTimeout.
+	// The per request timeout does not apply to plain TCP ports.
+	PerRequestTimeout float64
+	IdleTimeout       float64
+}
+
+type PrivateCaReturnValue struct {
+	CertificateAuthority pca.CfnCertificateAuthority
+	// the CA issues certificates once this is deployed
+	Activation pca.CfnCertificateAuthorityActivation
+}
+
+type ServiceConnectTls struct {
+	// issues the proxies' certificates, see NewPrivateCa
+	CertificateAuthorityArn *string
+	// the CA's activation when it is created in the same stack, nil when it is imported
+	Activation pca.CfnCertificateAuthorityActivation
+	KmsKey     kms.IKey
+	// assumed by ECS to issue the certificates, see NewServiceConnectTlsRole
+	Role iam.IRole
+}
+
+type CollectorProps struct {
+	// scrape the container's /metrics endpoint and publish it to CloudWatch as EMF
+	Metrics          bool
+	MetricsNamespace string
+	// the port the container serves /metrics on, scraped on localhost so it need not
+	// be one of the PortMappings and can stay off the public one
+	MetricsPort float64
+	// receive OTLP spans from the container on localhost:4318 and send them to X-Ray
+	Traces bool
 }
 
 type NewServiceProps struct {
-	ServiceName  string
-	Port         float64
+	ServiceName string
+	// name or ARN of one of the cluster's additional namespaces, empty joins the
+	// default one. The service only resolves the names published in its namespace.
+	Namespace string
+	// the container ports published in the Service Connect namespace, the others
+	// are only reachable inside the task. Empty runs the service in client only
+	// mode, it resolves the namespace's names without registering any endpoint.
+	ServiceConnectServices []ServiceConnectService
+	// optional, encrypts the traffic to every published port
+	Tls *ServiceConnectTls
+	// nil lets CDK create a group allowing all egress
+	SecurityGroups []ec2.ISecurityGroup
+
 	DesiredCount float64
 	MaxCount     *float64
 
@@ -97,13 +288,21 @@ type NewServiceConnectionProps struct {
 	ToConnection   ec2.Connections
 	ToPort         float64
 	FromConnection ec2.Connections
+	Description    string
 }
 
 type NewTargetGroupProps struct {
-	Name    string
-	Port    float64
-	Service ecs.FargateService
-	Vpc     ec2.Vpc
+	Name string
+	Port float64
+	// seconds, 0 keeps the default of 300
+	DeregistrationDelay float64
+	// seconds, 0 keeps the defaults. Threshold counts the consecutive checks that
+	// mark a target healthy or unhealthy, Timeout must be less than Interval.
+	HealthCheckInterval  float64
+	HealthCheckTimeout   float64
+	HealthCheckThreshold float64
+	Service              ecs.FargateService
+	Vpc                  ec2.IVpc
 }
 
 type AddListenerProps struct {
@@ -111,6 +310,8 @@ type AddListenerProps struct {
 	Port        float64
 	ALB         lb.ApplicationLoadBalancer
 	TargetGroup lb.ApplicationTargetGroup
+	// optional, serves HTTPS instead of HTTP
+	Certificate acm.ICertificate
 }
 
 type NewSourceActionProps struct {
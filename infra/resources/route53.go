@@ -0,0 +1,25 @@
+package resource
+
+import (
+	lb "github.com/aws/aws-cdk-go/awscdk/v2/awselasticloadbalancingv2"
+	route53 "github.com/aws/aws-cdk-go/awscdk/v2/awsroute53"
+	targets "github.com/aws/aws-cdk-go/awscdk/v2/awsroute53targets"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// GetHostedZoneFromId needs the zone's name as well, the records and certificates
+// are named after it.
+func (r *ResourceService) GetHostedZoneFromId(id string, zoneName string) route53.IHostedZone {
+	return route53.HostedZone_FromHostedZoneAttributes(r.S, jsii.String(id), &route53.HostedZoneAttributes{
+		HostedZoneId: jsii.String(id),
+		ZoneName:     jsii.String(zoneName),
+	})
+}
+
+func (r *ResourceService) NewAliasRecord(name string, zone route53.IHostedZone, recordName string, alb lb.IApplicationLoadBalancer) route53.ARecord {
+	return route53.NewARecord(r.S, jsii.String(name), &route53.ARecordProps{
+		Zone:       zone,
+		RecordName: jsii.String(recordName),
+		Target:     route53.RecordTarget_FromAlias(targets.NewLoadBalancerTarget(alb)),
+	})
+}
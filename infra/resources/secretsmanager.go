@@ -0,0 +1,22 @@
+package resource
+
+import (
+	sm "github.com/aws/aws-cdk-go/awscdk/v2/awssecretsmanager"
+	"github.com/aws/jsii-runtime-go"
+)
+
+func (r *ResourceService) NewSecret(name string) sm.Secret {
+	return sm.NewSecret(r.S, jsii.String(name), &sm.SecretProps{
+		SecretName: jsii.String(name),
+		GenerateSecretString: &sm.SecretStringGenerator{
+			PasswordLength:     jsii.Number(64),
+			ExcludePunctuation: jsii.Bool(true),
+		},
+	})
+}
+
+// GetSecretFromArn needs the complete ARN, ECS does not accept the partial one a
+// lookup by name returns.
+func (r *ResourceService) GetSecretFromArn(name string, arn *string) sm.ISecret {
+	return sm.Secret_FromSecretCompleteArn(r.S, jsii.String(name), arn)
+}
@@ -0,0 +1,19 @@
+package resource
+
+import (
+	ssm "github.com/aws/aws-cdk-go/awscdk/v2/awsssm"
+	"github.com/aws/jsii-runtime-go"
+)
+
+func (r *ResourceService) NewParameter(name string, value *string) ssm.StringParameter {
+	return ssm.NewStringParameter(r.S, jsii.String(name), &ssm.StringParameterProps{
+		ParameterName: jsii.String(name),
+		StringValue:   value,
+	})
+}
+
+// GetParameterFromName resolves the parameter when the stack is deployed, not when
+// it is synthesized, so it always reads the latest value.
+func (r *ResourceService) GetParameterFromName(name string) *string {
+	return ssm.StringParameter_ValueForStringParameter(r.S, jsii.String(name), nil)
+}
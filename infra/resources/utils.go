@@ -1,6 +1,9 @@
 package resource
 
-import "github.com/aws/jsii-runtime-go"
+import (
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/jsii-runtime-go"
+)
 
 func vToP(e []string) *[]*string {
 	tmp := []*string{}
@@ -10,3 +13,26 @@ func vToP(e []string) *[]*string {
 
 	return &tmp
 }
+
+func optionalString(e string) *string {
+	if e == "" {
+		return nil
+	}
+	return jsii.String(e)
+}
+
+// optionalSeconds keeps the CloudFormation default when e is 0, a zero duration is a
+// different setting, e.g. no deregistration delay at all.
+func optionalSeconds(e float64) awscdk.Duration {
+	if e == 0 {
+		return nil
+	}
+	return awscdk.Duration_Seconds(jsii.Number(e))
+}
+
+func optionalNumber(e float64) *float64 {
+	if e == 0 {
+		return nil
+	}
+	return jsii.Number(e)
+}
@@ -1,19 +1,261 @@
 package resource
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 
+	cdk "github.com/aws/aws-cdk-go/awscdk/v2"
 	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
 )
 
-func (r *ResourceService) NewVpc(vpcName string, cidr string) ec2.Vpc {
-	return ec2.NewVpc(r.S, jsii.String(vpcName), &ec2.VpcProps{
-		VpcName:     jsii.String(vpcName),
-		IpAddresses: ec2.IpAddresses_Cidr(jsii.String(cidr)),
-		SubnetConfiguration: &[]*ec2.SubnetConfiguration{
-			{Name: jsii.String(fmt.Sprintf("%s-public-", vpcName)), CidrMask: jsii.Number(24), SubnetType: ec2.SubnetType_PUBLIC},
-			{Name: jsii.String(fmt.Sprintf("%s-private-", vpcName)), CidrMask: jsii.Number(24), SubnetType: ec2.SubnetType_PRIVATE_WITH_EGRESS},
-		},
+func (r *ResourceService) NewVpc(e NewVpcProps) ec2.Vpc {
+	subnets := []*ec2.SubnetConfiguration{
+		{Name: jsii.String(fmt.Sprintf("%s-public-", e.VpcName)), CidrMask: jsii.Number(24), SubnetType: ec2.SubnetType_PUBLIC},
+		{Name: jsii.String(fmt.Sprintf("%s-private-", e.VpcName)), CidrMask: jsii.Number(24), SubnetType: ec2.SubnetType_PRIVATE_WITH_EGRESS},
+	}
+	if e.IsolatedSubnets {
+		subnets = append(subnets, &ec2.SubnetConfiguration{Name: jsii.String(fmt.Sprintf("%s-isolated-", e.VpcName)), CidrMask: jsii.Number(24), SubnetType: ec2.SubnetType_PRIVATE_ISOLATED})
+	}
+
+	// the private subnets reach the internet through the NAT, there must be one
+	if e.NatGateways != nil && *e.NatGateways < 1 {
+		panic(fmt.Sprintf("%s: at least one NAT gateway is required, got %g", e.VpcName, *e.NatGateways))
+	}
+	var natProvider ec2.NatProvider
+	if e.NatInstanceType != "" {
+		natProvider = ec2.NatProvider_Instance(&ec2.NatInstanceProps{InstanceType: ec2.NewInstanceType(jsii.String(e.NatInstanceType))})
+	}
+
+	var maxAzs *float64
+	if e.MaxAzs > 0 {
+		maxAzs = jsii.Number(e.MaxAzs)
+	}
+
+	gatewayEndpoints := map[string]*ec2.GatewayVpcEndpointOptions{}
+	for k, v := range e.GatewayEndpoints {
+		gatewayEndpoints[k] = &ec2.GatewayVpcEndpointOptions{Service: v}
+	}
+
+	vpc := ec2.NewVpc(r.S, jsii.String(e.VpcName), &ec2.VpcProps{
+		VpcName:             jsii.String(e.VpcName),
+		IpAddresses:         ec2.IpAddresses_Cidr(jsii.String(e.Cidr)),
+		MaxAzs:              maxAzs,
+		NatGateways:         e.NatGateways,
+		NatGatewayProvider:  natProvider,
+		SubnetConfiguration: &subnets,
+		GatewayEndpoints:    &gatewayEndpoints,
+	})
+
+	for _, v := range e.InterfaceEndpoints {
+		vpc.AddInterfaceEndpoint(jsii.String(*v.ShortName()), &ec2.InterfaceVpcEndpointOptions{
+			Service:           v,
+			PrivateDnsEnabled: jsii.Bool(true),
+			Subnets:           &ec2.SubnetSelection{SubnetType: ec2.SubnetType_PRIVATE_WITH_EGRESS},
+		})
+	}
+
+	return vpc
+}
+
+func (r *ResourceService) GetVpcFromAttributes(vpcName string, e GetVpcFromAttributesProps) ec2.IVpc {
+	return ec2.Vpc_FromVpcAttributes(r.S, jsii.String(vpcName), &ec2.VpcAttributes{
+		VpcId:             e.VpcId,
+		AvailabilityZones: &e.AvailabilityZones,
+		PublicSubnetIds:   &e.PublicSubnetIds,
+		PrivateSubnetIds:  &e.PrivateSubnetIds,
+	})
+}
+
+// NewFlowLog records the traffic of every network interface of the VPC, to a log
+// group or under a prefix of a bucket.
+func (r *ResourceService) NewFlowLog(e NewFlowLogProps) ec2.FlowLog {
+	var destination ec2.FlowLogDestination
+	switch {
+	case e.LogGroup != nil && e.Bucket != nil:
+		panic(fmt.Sprintf("%s: flow logs go to a log group or a bucket, not both", e.Name))
+	case e.LogGroup != nil:
+		destination = ec2.FlowLogDestination_ToCloudWatchLogs(e.LogGroup, nil)
+	case e.Bucket != nil:
+		destination = ec2.FlowLogDestination_ToS3(e.Bucket, optionalString(e.Prefix), nil)
+	default:
+		panic(fmt.Sprintf("%s: flow logs need a log group or a bucket", e.Name))
+	}
+
+	trafficType := e.TrafficType
+	if trafficType == "" {
+		trafficType = ec2.FlowLogTrafficType_ALL
+	}
+
+	return ec2.NewFlowLog(r.S, jsii.String(e.Name), &ec2.FlowLogProps{
+		FlowLogName:  jsii.String(e.Name),
+		ResourceType: ec2.FlowLogResourceType_FromVpc(e.Vpc),
+		Destination:  destination,
+		TrafficType:  trafficType,
 	})
 }
+
+// LookupVpc finds an existing VPC by id or tags when the app is synthesized and
+// caches it in cdk.context.json, the stack needs an explicit account and region.
+func (r *ResourceService) LookupVpc(vpcName string, e LookupVpcProps) ec2.IVpc {
+	if e.VpcId == "" && len(e.Tags) == 0 {
+		panic(fmt.Sprintf("%s: a VPC id or tags are required to look it up", vpcName))
+	}
+
+	var tags *map[string]*string
+	if len(e.Tags) > 0 {
+		tmp := map[string]*string{}
+		for k, v := range e.Tags {
+			tmp[k] = jsii.String(v)
+		}
+		tags = &tmp
+	}
+
+	return ec2.Vpc_FromLookup(r.S, jsii.String(vpcName), &ec2.VpcLookupOptions{
+		VpcId: optionalString(e.VpcId),
+		Tags:  tags,
+	})
+}
+
+func (r *ResourceService) NewSecurityGroup(e NewSecurityGroupProps) ec2.SecurityGroup {
+	sg := ec2.NewSecurityGroup(r.S, jsii.String(e.Name), &ec2.SecurityGroupProps{
+		SecurityGroupName: jsii.String(e.Name),
+		Description:       jsii.String(e.Description),
+		Vpc:               e.Vpc,
+		AllowAllOutbound:  jsii.Bool(false),
+	})
+
+	for _, v := range e.EgressPorts {
+		sg.AddEgressRule(ec2.Peer_AnyIpv4(), ec2.Port_Tcp(jsii.Number(v)), jsii.String(fmt.Sprintf("to anywhere on %g", v)), nil)
+	}
+
+	return sg
+}
+
+// GetSecurityGroupFromId lets this stack add rules to a group another stack owns.
+func (r *ResourceService) GetSecurityGroupFromId(name string, id *string) ec2.ISecurityGroup {
+	return ec2.SecurityGroup_FromSecurityGroupId(r.S, jsii.String(name), id, nil)
+}
+
+// IngressRules lists the ingress rules of the stack's security groups, standalone
+// and inline. Groups are named by construct path and sources by group, CIDR or the
+// export or SSM parameter they are imported from.
+func (r *ResourceService) IngressRules() []IngressRule {
+	// logical id to construct path, e.g. the VPC or a security group, and to the SSM
+	// parameter a template parameter reads
+	paths := map[string]string{}
+	parameters := map[string]string{}
+	for _, c := range *r.S.Node().FindAll(constructs.ConstructOrder_PREORDER) {
+		switch cfn := c.(type) {
+		case cdk.CfnResource:
+			path := *c.Node().Path()
+			if *c.Node().Id() == "Resource" {
+				path = *c.Node().Scope().Node().Path()
+			}
+			paths[*r.S.GetLogicalId(cfn)] = path
+		case cdk.CfnParameter:
+			parameters[*r.S.GetLogicalId(cfn)] = fmt.Sprint(cfn.Default())
+		}
+	}
+
+	var render func(v interface{}) string
+	render = func(v interface{}) string {
+		switch t := v.(type) {
+		case nil:
+			return ""
+		case string:
+			return t
+		case []interface{}:
+			tmp := []string{}
+			for _, v := range t {
+				tmp = append(tmp, render(v))
+			}
+			return strings.Join(tmp, ",")
+		case map[string]interface{}:
+			if att, ok := t["Fn::GetAtt"].([]interface{}); ok && len(att) == 2 {
+				path, ok := paths[fmt.Sprint(att[0])]
+				if !ok {
+					path = fmt.Sprint(att[0])
+				}
+				if att[1] == "GroupId" {
+					return path
+				}
+				return fmt.Sprintf("%s.%v", path, att[1])
+			}
+			if join, ok := t["Fn::Join"].([]interface{}); ok && len(join) == 2 {
+				parts, _ := join[1].([]interface{})
+				tmp := []string{}
+				for _, v := range parts {
+					tmp = append(tmp, render(v))
+				}
+				return strings.Join(tmp, fmt.Sprint(join[0]))
+			}
+			if name, ok := t["Fn::ImportValue"]; ok {
+				return fmt.Sprintf("import %s", render(name))
+			}
+			if ref, ok := t["Ref"]; ok {
+				if name, ok := parameters[fmt.Sprint(ref)]; ok {
+					return fmt.Sprintf("parameter %s", name)
+				}
+				return render(ref)
+			}
+			b, _ := json.Marshal(t)
+			return string(b)
+		default:
+			return fmt.Sprint(t)
+		}
+	}
+	describe := func(v interface{}) string {
+		if s, ok := v.(*string); v == nil || ok && s == nil {
+			return ""
+		}
+		return render(r.S.Resolve(v))
+	}
+	port := func(v interface{}) float64 {
+		if f, ok := v.(*float64); v == nil || ok && f == nil {
+			return 0
+		}
+		f, _ := r.S.Resolve(v).(float64)
+		return f
+	}
+
+	rules := []IngressRule{}
+	for _, c := range *r.S.Node().FindAll(constructs.ConstructOrder_PREORDER) {
+		switch cfn := c.(type) {
+		case ec2.CfnSecurityGroup:
+			inline, _ := r.S.Resolve(cfn.SecurityGroupIngress()).([]interface{})
+			for _, v := range inline {
+				rule, _ := v.(map[string]interface{})
+				source := render(rule["sourceSecurityGroupId"])
+				if source == "" {
+					source = render(rule["cidrIp"]) + render(rule["cidrIpv6"])
+				}
+				rules = append(rules, IngressRule{
+					SecurityGroup: *c.Node().Scope().Node().Path(),
+					Source:        source,
+					Protocol:      render(rule["ipProtocol"]),
+					FromPort:      port(rule["fromPort"]),
+					ToPort:        port(rule["toPort"]),
+					Description:   render(rule["description"]),
+				})
+			}
+		case ec2.CfnSecurityGroupIngress:
+			source := describe(cfn.SourceSecurityGroupId())
+			if source == "" {
+				source = describe(cfn.CidrIp()) + describe(cfn.CidrIpv6())
+			}
+			rules = append(rules, IngressRule{
+				SecurityGroup: describe(cfn.GroupId()),
+				Source:        source,
+				Protocol:      *cfn.IpProtocol(),
+				FromPort:      port(cfn.FromPort()),
+				ToPort:        port(cfn.ToPort()),
+				Description:   describe(cfn.Description()),
+			})
+		}
+	}
+
+	return rules
+}
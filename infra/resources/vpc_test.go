@@ -0,0 +1,73 @@
+package resource
+
+import (
+	"reflect"
+	"testing"
+
+	cdk "github.com/aws/aws-cdk-go/awscdk/v2"
+	ec2 "github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/jsii-runtime-go"
+)
+
+func TestIngressRules(t *testing.T) {
+	app := cdk.NewApp(nil)
+	owner := &ResourceService{S: cdk.NewStack(app, jsii.String("Owner"), nil)}
+	caller := &ResourceService{S: cdk.NewStack(app, jsii.String("Caller"), nil)}
+	newVpc := func(r *ResourceService) ec2.IVpc {
+		return ec2.NewVpc(r.S, jsii.String("vpc"), &ec2.VpcProps{MaxAzs: jsii.Number(1), NatGateways: jsii.Number(0)})
+	}
+
+	// the owner's group only has the rules its callers add from their own stack
+	server := owner.NewSecurityGroup(NewSecurityGroupProps{Name: "server", Description: "server", Vpc: newVpc(owner)})
+
+	callerVpc := newVpc(caller)
+	client := caller.NewSecurityGroup(NewSecurityGroupProps{Name: "client", Description: "client", Vpc: callerVpc, EgressPorts: []float64{443}})
+	alb := caller.NewSecurityGroup(NewSecurityGroupProps{Name: "alb", Description: "alb", Vpc: callerVpc})
+	alb.AddIngressRule(ec2.Peer_Ipv4(jsii.String("10.0.0.0/16")), ec2.Port_TcpRange(jsii.Number(80), jsii.Number(81)), jsii.String("from the VPC"), nil)
+	caller.NewServiceConnection(NewServiceConnectionProps{
+		ToConnection:   ec2.NewConnections(&ec2.ConnectionsProps{SecurityGroups: &[]ec2.ISecurityGroup{caller.GetSecurityGroupFromId("server", server.SecurityGroupId())}}),
+		ToPort:         9001,
+		FromConnection: client.Connections(),
+		Description:    "client to server",
+	})
+	caller.NewServiceConnection(NewServiceConnectionProps{ToConnection: client.Connections(), ToPort: 8000, FromConnection: alb.Connections()})
+	caller.NewServiceConnection(NewServiceConnectionProps{
+		ToConnection:   caller.GetSecurityGroupFromId("billing", caller.GetParameterFromName("/p/billing/security-group-id")).Connections(),
+		ToPort:         8080,
+		FromConnection: client.Connections(),
+		Description:    "client to billing",
+	})
+
+	// the references to the owner's group resolve to its export once synthesized
+	app.Synth(nil)
+
+	tests := []struct {
+		name  string
+		stack *ResourceService
+		want  []IngressRule
+	}{
+		{name: "owner", stack: owner, want: []IngressRule{}},
+		{
+			name:  "caller",
+			stack: caller,
+			want: []IngressRule{
+				// standalone, between groups of the same stack
+				{SecurityGroup: "Caller/client", Source: "Caller/alb", Protocol: "tcp", FromPort: 8000, ToPort: 8000, Description: "from Calleralb89585482:8000"},
+				// inline
+				{SecurityGroup: "Caller/alb", Source: "10.0.0.0/16", Protocol: "tcp", FromPort: 80, ToPort: 81, Description: "from the VPC"},
+				// standalone, on an imported group
+				{SecurityGroup: "import Owner:ExportsOutputFnGetAttserver2E685835GroupIdDBE8E756", Source: "Caller/client", Protocol: "tcp", FromPort: 9001, ToPort: 9001, Description: "client to server"},
+				// standalone, on a group imported from a parameter
+				{SecurityGroup: "parameter /p/billing/security-group-id", Source: "Caller/client", Protocol: "tcp", FromPort: 8080, ToPort: 8080, Description: "client to billing"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stack.IngressRules(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IngressRules =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}